@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// alertURL is a Slack compatible incoming webhook URL that alerts are
+// posted to, if it is set.
+var alertURL string
+
+// raiseAlert tells the TinyHCI maintainers about a problem that needs
+// someone to look at the test hardware.
+func raiseAlert(kind, message string, args ...any) {
+	alertsRaised.inc(kind)
+	slog.Error(message, append([]any{"alert", kind}, args...)...)
+
+	if alertURL == "" {
+		return
+	}
+
+	go func() {
+		data, err := json.Marshal(map[string]string{"text": "TinyHCI: " + message})
+		if err != nil {
+			return
+		}
+
+		c := http.Client{Timeout: 10 * time.Second}
+		resp, err := c.Post(alertURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			slog.Error("unable to post alert", "err", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
@@ -1,125 +1,214 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// used when a board does not set its own timeouts
+	defaultFlashTimeout = 10 * time.Minute
+	defaultTestTimeout  = 3 * time.Minute
+
+	// how many times to retry a run that failed because of the hardware
+	defaultRetries = 1
+)
+
 type Board struct {
-	target      string
-	displayname string
-	image       string
-	port        string
-	baud        int
-	resetpause  time.Duration
-	enabled     bool
+	target       string
+	displayname  string
+	image        string
+	port         string
+	baud         int
+	resetpause   time.Duration
+	flashtimeout time.Duration
+	testtimeout  time.Duration
+	retries      int
+	enabled      bool
+
+	// usb is the USB vendor and product ID of the board, such as "2e8a:000a".
+	usb string
+
+	// smokeflash is set if the health checks may flash the board.
+	smokeflash bool
 }
 
 var (
-	boards = []*Board{
-		&Board{
-			target:      "itsybitsy-m4",
-			displayname: "Adafruit ItsyBitsy-M4",
-			port:        "itsybitsy_m4",
-			baud:        115200,
-			resetpause:  5 * time.Second,
-			enabled:     false,
-		},
-		&Board{
-			target:      "arduino",
-			displayname: "Arduino Uno",
-			image:       "arduino.svg",
-			port:        "arduino_uno",
-			baud:        57600,
-			resetpause:  5 * time.Second,
-			enabled:     true,
-		},
-		&Board{
-			target:      "arduino-nano33",
-			displayname: "Arduino Nano33 IoT",
-			image:       "arduino-nano33.svg",
-			port:        "arduino_nano33",
-			baud:        115200,
-			resetpause:  15 * time.Second,
-			enabled:     true,
-		},
-		&Board{
-			target:      "microbit-v2",
-			displayname: "bbc:microbit",
-			port:        "microbit",
-			baud:        115200,
-			resetpause:  9 * time.Second,
-			enabled:     false,
-		},
-		&Board{
-			target:      "hifive1b",
-			displayname: "SiFive HiFive1 Rev.B",
-			port:        "hifive1b",
-			baud:        115200,
-			resetpause:  30 * time.Second,
-			enabled:     false,
-		},
-		&Board{
-			target:      "circuitplay-express",
-			displayname: "Adafruit Circuit Playground Express",
-			image:       "circuit-playground.svg",
-			port:        "circuitplay_express",
-			baud:        115200,
-			resetpause:  15 * time.Second,
-			enabled:     true,
-		},
-		&Board{
-			target:      "maixbit",
-			displayname: "Sipeed MAix BiT",
-			port:        "maixbit00",
-			baud:        115200,
-			resetpause:  10 * time.Second,
-			enabled:     false,
-		},
-		&Board{
-			target:      "itsybitsy-nrf52840",
-			displayname: "Adafruit ItsyBitsy nRF52840",
-			image:       "itsybitsy-nrf52840.svg",
-			port:        "itsybitsy_nrf52840",
-			baud:        115200,
-			resetpause:  7 * time.Second,
-			enabled:     true,
-		},
-		&Board{
-			target:      "stm32f4disco-1",
-			displayname: "STM32F407 Discovery",
-			image:       "stm32f407-discovery.svg",
-			port:        "stm32f4disco",
-			baud:        115200,
-			resetpause:  5 * time.Second,
-			enabled:     true,
-		},
-		&Board{
-			target:      "pico",
-			displayname: "Raspberry Pi RP2040 Pico",
-			image:       "pico.svg",
-			port:        "pico",
-			baud:        115200,
-			resetpause:  10 * time.Second,
-			enabled:     true,
-		},
-		&Board{
-			target:      "xiao-esp32c3",
-			displayname: "Seeed Studio Xiao ESP32-C3",
-			image:       "xiao-esp32c3.svg",
-			port:        "xiao_esp32c3",
-			baud:        115200,
-			resetpause:  10 * time.Second,
-			enabled:     true,
-		},
-	}
+	// boards are loaded from the boards config file, and replaced
+	// as a whole whenever the config is reloaded.
+	boards   []*Board
+	boardsMu sync.RWMutex
 )
 
+// boardsConfig is the format of the boards config file.
+type boardsConfig struct {
+	Boards []boardConfig `json:"boards"`
+}
+
+// boardConfig is the configuration for a single board.
+type boardConfig struct {
+	Target      string   `json:"target"`
+	DisplayName string   `json:"displayname"`
+	Image       string   `json:"image"`
+	Port        string   `json:"port"`
+	Baud        int      `json:"baud"`
+	ResetPause  duration `json:"resetpause"`
+
+	// FlashTimeout and TestTimeout are optional.
+	FlashTimeout duration `json:"flashtimeout"`
+	TestTimeout  duration `json:"testtimeout"`
+
+	// Retries is optional.
+	Retries *int `json:"retries"`
+
+	// USB and SmokeFlash are optional.
+	USB        string `json:"usb"`
+	SmokeFlash bool   `json:"smokeflash"`
+
+	Enabled bool `json:"enabled"`
+}
+
+// duration is a time.Duration that is written as a string such as "5s"
+// in the config file.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("duration must be a string such as \"5s\"")
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadBoards reads the board definitions from the config file.
+func loadBoards(filename string) ([]*Board, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg boardsConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			line := bytes.Count(data[:serr.Offset], []byte("\n")) + 1
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if len(cfg.Boards) == 0 {
+		return nil, fmt.Errorf("%s: no boards defined", filename)
+	}
+
+	result := make([]*Board, 0, len(cfg.Boards))
+	seen := make(map[string]bool)
+	for i, bc := range cfg.Boards {
+		if err := bc.validate(); err != nil {
+			return nil, fmt.Errorf("%s: board %d (%s): %v", filename, i+1, bc.Target, err)
+		}
+		if seen[bc.Target] {
+			return nil, fmt.Errorf("%s: board %d (%s): duplicate target", filename, i+1, bc.Target)
+		}
+		seen[bc.Target] = true
+
+		board := &Board{
+			target:       bc.Target,
+			displayname:  bc.DisplayName,
+			image:        bc.Image,
+			port:         bc.Port,
+			baud:         bc.Baud,
+			resetpause:   time.Duration(bc.ResetPause),
+			flashtimeout: cmp.Or(time.Duration(bc.FlashTimeout), defaultFlashTimeout),
+			testtimeout:  cmp.Or(time.Duration(bc.TestTimeout), defaultTestTimeout),
+			retries:      defaultRetries,
+			enabled:      bc.Enabled,
+			usb:          strings.ToLower(bc.USB),
+			smokeflash:   bc.SmokeFlash,
+		}
+		if bc.Retries != nil {
+			board.retries = *bc.Retries
+		}
+		result = append(result, board)
+	}
+
+	return result, nil
+}
+
+var validUSBID = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{4}$`)
+
+// validate checks that the board config has all of the required fields.
+func (bc boardConfig) validate() error {
+	switch {
+	case bc.Target == "":
+		return errors.New("target is required")
+	case bc.DisplayName == "":
+		return errors.New("displayname is required")
+	case bc.Port == "":
+		return errors.New("port is required")
+	case strings.Contains(bc.Port, "/"):
+		return errors.New("port must be the name of the device in /dev, not a path")
+	case bc.Baud <= 0:
+		return errors.New("baud must be greater than 0")
+	case bc.ResetPause < 0:
+		return errors.New("resetpause must not be negative")
+	case bc.FlashTimeout < 0:
+		return errors.New("flashtimeout must not be negative")
+	case bc.TestTimeout < 0:
+		return errors.New("testtimeout must not be negative")
+	case bc.Retries != nil && *bc.Retries < 0:
+		return errors.New("retries must not be negative")
+	case bc.USB != "" && !validUSBID.MatchString(bc.USB):
+		return errors.New("usb must be a vendor and product ID such as \"2e8a:000a\"")
+	}
+	return nil
+}
+
+// Boards returns all of the currently configured boards.
+func Boards() []*Board {
+	boardsMu.RLock()
+	defer boardsMu.RUnlock()
+
+	return boards
+}
+
+// setBoards replaces the configured boards, and starts a worker
+// for any boards that did not have one yet.
+func setBoards(newboards []*Board) {
+	boardsMu.Lock()
+	boards = newboards
+	boardsMu.Unlock()
+
+	for _, board := range newboards {
+		startWorker(board.target)
+	}
+}
+
 // GetBoard returns the board for this target.
 func GetBoard(target string) *Board {
+	boardsMu.RLock()
+	defer boardsMu.RUnlock()
+
 	for _, b := range boards {
 		if b.target == target {
 			return b
@@ -128,47 +217,62 @@ func GetBoard(target string) *Board {
 	return nil
 }
 
-func (board *Board) flash(sha string) (string, error) {
+// flash builds the tests for the board and flashes them. The options
+// can add more diagnostics to the output.
+func (board *Board) flash(ctx context.Context, sha string, opts runOptions, w io.Writer) (string, error) {
 	pwd, err := os.Getwd()
 	if err != nil {
 		return err.Error(), err
 	}
 
-	realdev, err := os.Readlink("/dev/" + board.port)
+	dev, err := board.device()
 	if err != nil {
 		return err.Error(), err
 	}
 
 	buildtag := fmt.Sprintf("tinygohci:%s", sha[:7])
-	device := fmt.Sprintf("--device=/dev/%s:/dev/%s:rwm", realdev, realdev)
-	port := fmt.Sprintf("-port=/dev/%s", realdev)
+	device := fmt.Sprintf("--device=%s:%s:rwm", dev, dev)
+	port := fmt.Sprintf("-port=%s", dev)
 	workdir := fmt.Sprintf("/src/%s", board.target)
-	out, err := exec.Command("docker", "run",
+	name := fmt.Sprintf("tinyhci-%s-%s", board.target, sha[:7])
+	size := "short"
+	args := []string{"run",
+		"--name", name,
 		device,
 		"-v", "/media:/media:shared",
-		"-v", pwd+":/src",
+		"-v", pwd + ":/src",
 		"-w", workdir,
 		"-v", "/dev/bus/usb:/dev/bus/usb",
 		"--device-cgroup-rule", "a 189:* rwm",
 		"--rm",
 		buildtag,
-		"tinygo", "flash",
-		"-size", "short",
+		"tinygo", "flash"}
+	if opts.verbose {
+		size = "full"
+		args = append(args, "-x")
+	}
+	args = append(args,
+		"-size", size,
 		"-timeout", "30s",
 		"-target", board.target,
 		port,
-		".").CombinedOutput()
-	return string(out), err
+		".")
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	out, err := streamCommand(cmd, w)
+	if ctx.Err() != nil {
+		// killing the docker client does not stop the container
+		exec.Command("docker", "kill", name).Run()
+	}
+	return out, err
 }
 
-func (board *Board) test() (string, error) {
-	realdev, err := os.Readlink("/dev/" + board.port)
+func (board *Board) test(ctx context.Context, w io.Writer) (string, error) {
+	dev, err := board.device()
 	if err != nil {
 		return err.Error(), err
 	}
-	port := fmt.Sprintf("/dev/%s", realdev)
 	br := strconv.Itoa(board.baud)
 
-	out, err := exec.Command("./build/testrunner", port, br, "2").CombinedOutput()
-	return string(out), err
+	cmd := exec.CommandContext(ctx, "./build/testrunner", dev, br, "2")
+	return streamCommand(cmd, w)
 }
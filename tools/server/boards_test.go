@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadBoards(t *testing.T) {
+	const pico = `{"target": "pico", "displayname": "Raspberry Pi Pico", "port": "ttyACM0", "baud": 115200`
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "no boards",
+			config:  `{"boards": []}`,
+			wantErr: "no boards defined",
+		},
+		{
+			name:    "syntax error",
+			config:  "{\n\"boards\": [\n" + pico + ",}\n]}",
+			wantErr: "boards.json:3: invalid character",
+		},
+		{
+			name:    "unknown field",
+			config:  `{"boards": [` + pico + `, "speed": 1}]}`,
+			wantErr: `unknown field "speed"`,
+		},
+		{
+			name:    "duplicate target",
+			config:  `{"boards": [` + pico + `}, ` + pico + `}]}`,
+			wantErr: "board 2 (pico): duplicate target",
+		},
+		{
+			name:    "missing target",
+			config:  `{"boards": [{"displayname": "Raspberry Pi Pico", "port": "ttyACM0", "baud": 115200}]}`,
+			wantErr: "board 1 (): target is required",
+		},
+		{
+			name:    "missing displayname",
+			config:  `{"boards": [{"target": "pico", "port": "ttyACM0", "baud": 115200}]}`,
+			wantErr: "board 1 (pico): displayname is required",
+		},
+		{
+			name:    "missing port",
+			config:  `{"boards": [{"target": "pico", "displayname": "Raspberry Pi Pico", "baud": 115200}]}`,
+			wantErr: "port is required",
+		},
+		{
+			name:    "port path",
+			config:  `{"boards": [{"target": "pico", "displayname": "Raspberry Pi Pico", "port": "/dev/ttyACM0", "baud": 115200}]}`,
+			wantErr: "port must be the name of the device in /dev",
+		},
+		{
+			name:    "missing baud",
+			config:  `{"boards": [{"target": "pico", "displayname": "Raspberry Pi Pico", "port": "ttyACM0"}]}`,
+			wantErr: "baud must be greater than 0",
+		},
+		{
+			name:    "bad duration",
+			config:  `{"boards": [` + pico + `, "resetpause": 5}]}`,
+			wantErr: `duration must be a string such as "5s"`,
+		},
+		{
+			name:    "negative timeout",
+			config:  `{"boards": [` + pico + `, "flashtimeout": "-1m"}]}`,
+			wantErr: "flashtimeout must not be negative",
+		},
+		{
+			name:    "negative retries",
+			config:  `{"boards": [` + pico + `, "retries": -1}]}`,
+			wantErr: "retries must not be negative",
+		},
+		{
+			name:    "usb without product",
+			config:  `{"boards": [` + pico + `, "usb": "2e8a"}]}`,
+			wantErr: "usb must be a vendor and product ID",
+		},
+		{
+			name:    "usb not hex",
+			config:  `{"boards": [` + pico + `, "usb": "2e8a:000g"}]}`,
+			wantErr: "usb must be a vendor and product ID",
+		},
+		{
+			name:   "valid",
+			config: `{"boards": [` + pico + `, "usb": "2E8A:000A"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "boards.json")
+			if err := os.WriteFile(filename, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadBoards(filename)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("loadBoards() returned error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("loadBoards() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadBoardsDefaults(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "boards.json")
+	config := `{"boards": [
+		{"target": "pico", "displayname": "Raspberry Pi Pico", "port": "ttyACM0", "baud": 115200, "usb": "2E8A:000A", "enabled": true},
+		{"target": "microbit", "displayname": "BBC micro:bit", "port": "ttyACM1", "baud": 115200, "resetpause": "2s", "testtimeout": "5m", "retries": 0}
+	]}`
+	if err := os.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadBoards(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Board{
+		{
+			target:       "pico",
+			displayname:  "Raspberry Pi Pico",
+			port:         "ttyACM0",
+			baud:         115200,
+			flashtimeout: defaultFlashTimeout,
+			testtimeout:  defaultTestTimeout,
+			retries:      defaultRetries,
+			enabled:      true,
+			usb:          "2e8a:000a",
+		},
+		{
+			target:       "microbit",
+			displayname:  "BBC micro:bit",
+			port:         "ttyACM1",
+			baud:         115200,
+			resetpause:   2 * time.Second,
+			flashtimeout: defaultFlashTimeout,
+			testtimeout:  5 * time.Minute,
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("loadBoards() returned %d boards, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("board %d = %+v, want %+v", i+1, *got[i], want[i])
+		}
+	}
+}
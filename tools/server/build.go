@@ -1,7 +1,13 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/v84/github"
@@ -9,51 +15,474 @@ import (
 
 // Build is a specific build to be tested.
 type Build struct {
-	pendingCI bool
+	state     BuildState
 	started   time.Time
-	binaryURL string
+	completed time.Time
 	sha       string
-	suite     *github.CheckSuite
 
-	// runs are all of the checkruns for this build.
-	// key is the target.
-	runs map[string]*github.CheckRun
+	// received is when the commit was pushed, which orders the builds for
+	// the same head. A re-run keeps the time of the build that it re-runs.
+	received time.Time
+
+	// queued is when the build was queued to be tested, after its CI
+	// had finished.
+	queued time.Time
+
+	// workflowRun is the ID of the CI workflow run that built the TinyGo
+	// binary for the commit.
+	workflowRun int64
+
+	// head is the repo and branch that the commit was pushed to,
+	// such as "tinygo-org/tinygo:dev".
+	head string
+
+	// base is the repo and branch that the test results are compared
+	// against, such as "tinygo-org/tinygo:dev".
+	base string
+
+	// options change how the boards are run, such as for a re-run
+	// with more diagnostics.
+	options runOptions
+
+	// ctx is cancelled to stop the build right away.
+	ctx  context.Context
+	stop context.CancelFunc
+
+	// mu protects state, queued, completed, head, base, workflowRun,
+	// cancelReason, the logger, runs, runStates and results, which are
+	// updated by the webhook handler and the board workers.
+	mu sync.Mutex
+
+	// cancelReason is set once the build has been cancelled.
+	cancelReason string
+
+	// logger and logfile are set while the build is running, so that
+	// its records are also written to its own log file.
+	logger  *slog.Logger
+	logfile *os.File
+
+	// runs are the IDs of all of the check runs for this build
+	// that have not completed yet. key is the target.
+	runs map[string]int64
+
+	// runStates are what each board run is currently doing. key is the target.
+	runStates map[string]RunState
+
+	// results are the check runs that have completed. key is the target.
+	results map[string]RunResult
+}
+
+// RunResult is the result of a completed check run.
+type RunResult struct {
+	ID         int64  `json:"id"`
+	Conclusion string `json:"conclusion"`
 }
 
 // NewBuild returns a new Build.
 func NewBuild(sha string) *Build {
+	ctx, stop := context.WithCancel(context.Background())
+	now := time.Now()
 	return &Build{
-		sha:  sha,
-		runs: make(map[string]*github.CheckRun),
+		sha:       sha,
+		state:     BuildQueued,
+		started:   now,
+		received:  now,
+		queued:    now,
+		ctx:       ctx,
+		stop:      stop,
+		runs:      make(map[string]int64),
+		runStates: make(map[string]RunState),
+		results:   make(map[string]RunResult),
 	}
 }
 
-func (build Build) processBoardRun(board *Board) {
-	if !board.enabled {
-		log.Printf("Board %s has been disabled, so passing.\n", board.displayname)
-		build.passCheckRun(board.target, "Board disabled in TinyHCI.")
+// buildRegistry is the set of all known builds, which is used by the
+// webhook handler, the build processor and the dashboard at the same time.
+type buildRegistry struct {
+	mu sync.Mutex
+	// key is sha
+	builds map[string]*Build
+}
+
+// builds are all of the known builds.
+var builds = &buildRegistry{builds: make(map[string]*Build)}
+
+// add adds the build, replacing any other build for the same sha.
+func (r *buildRegistry) add(build *Build) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.builds[build.sha] = build
+}
+
+// addNew adds the build, unless there is already a build for the same sha.
+// It returns true if the build was added.
+func (r *buildRegistry) addNew(build *Build) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.builds[build.sha]; ok {
+		return false
+	}
+	r.builds[build.sha] = build
+	return true
+}
+
+// replaceFinished adds the build, unless there is already a build for the
+// same sha that has not finished. It returns true if the build was added.
+func (r *buildRegistry) replaceFinished(build *Build) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if prev, ok := r.builds[build.sha]; ok && !prev.getState().finished() {
+		return false
+	}
+	r.builds[build.sha] = build
+	return true
+}
+
+// get returns the build for this sha.
+func (r *buildRegistry) get(sha string) (*Build, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	build, ok := r.builds[sha]
+	return build, ok
+}
+
+// all returns all of the known builds.
+func (r *buildRegistry) all() []*Build {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*Build, 0, len(r.builds))
+	for _, build := range r.builds {
+		result = append(result, build)
+	}
+	return result
+}
+
+// getHead returns the repo and branch that the commit was pushed to.
+func (build *Build) getHead() string {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	return build.head
+}
+
+// getBase returns the repo and branch that the test results are compared against.
+func (build *Build) getBase() string {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	return build.base
+}
+
+// getWorkflowRun returns the ID of the CI workflow run for the TinyGo binary.
+func (build *Build) getWorkflowRun() int64 {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	return build.workflowRun
+}
+
+// setWorkflowRun records the CI workflow run that built the TinyGo binary,
+// and the head and base branches of the commit that it ran for.
+func (build *Build) setWorkflowRun(wr *github.WorkflowRun) {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	build.workflowRun = wr.GetID()
+	if head := workflowRunHead(wr); head != "" {
+		build.head = head
+	}
+	build.base = workflowRunBase(wr)
+}
+
+// timedOut returns true if the context's deadline has passed.
+func timedOut(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// cancelled returns the reason the build was cancelled, if it was.
+func (build *Build) cancelled() (string, bool) {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	return build.cancelReason, build.cancelReason != ""
+}
+
+// run returns the check run ID for this target.
+func (build *Build) run(target string) (int64, bool) {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	run, ok := build.runs[target]
+	return run, ok
+}
+
+// setRun sets the check run ID for this target.
+func (build *Build) setRun(target string, run int64) {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	build.runs[target] = run
+	build.runStates[target] = RunPending
+}
+
+// finishRun moves the check run for this target to the results
+// once it has completed.
+func (build *Build) finishRun(target string, conclusion string) {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	build.results[target] = RunResult{ID: build.runs[target], Conclusion: conclusion}
+	delete(build.runs, target)
+	delete(build.runStates, target)
+}
+
+// runResults returns the results of the completed check runs.
+func (build *Build) runResults() map[string]RunResult {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	results := make(map[string]RunResult, len(build.results))
+	for target, r := range build.results {
+		results[target] = r
+	}
+	return results
+}
+
+// targets returns the targets that still have check runs for this build.
+func (build *Build) targets() []string {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	targets := make([]string, 0, len(build.runs))
+	for target := range build.runs {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	return targets
+}
+
+// finish marks the build as completed, or as cancelled if it was cancelled.
+func (build *Build) finish() {
+	if build.getState().finished() {
+		return
 	}
 
-	log.Printf("Flashing board %s\n", board.displayname)
-	fout, err := board.flash(build.sha)
-	if err != nil {
-		log.Println(err)
-		log.Println(fout)
-		build.failCheckRun(board.target, boardHeading(board)+flashout(fout))
+	build.log().Info("tests completed")
+	state := BuildCompleted
+	if _, ok := build.cancelled(); ok {
+		state = BuildCancelled
+	}
+	if err := build.transition(state); err != nil {
+		build.log().Error("unable to finish build", "err", err)
 		return
 	}
+	build.mu.Lock()
+	build.completed = time.Now()
+	build.mu.Unlock()
+	build.save()
+	build.closeLog()
+
+	buildsCompleted.inc(build.conclusion())
+}
+
+// conclusion returns the overall conclusion of the build: "success" if every
+// board succeeded, "failure" if any board failed, or otherwise the first
+// conclusion that was not a success.
+func (build *Build) conclusion() string {
+	results := build.runResults()
+	conclusion := "success"
+	for _, target := range sortedKeys(results) {
+		switch c := results[target].Conclusion; {
+		case c == "failure":
+			return c
+		case c != "success" && conclusion == "success":
+			conclusion = c
+		}
+	}
+	return conclusion
+}
 
-	time.Sleep(board.resetpause)
+func (build *Build) processBoardRun(board *Board) {
+	if !board.enabled && !build.options.allBoards {
+		build.log().Info("board has been disabled, so passing", "target", board.target)
+		build.passCheckRun(board.target, "Board disabled in TinyHCI.")
+		return
+	}
 
-	log.Printf("Running tests on board %s\n", board.displayname)
-	out, err := board.test()
-	if err != nil {
-		log.Println(err)
-		build.failCheckRun(board.target, boardHeading(board)+flashout(fout)+testsout(out))
+	if err := board.checkPresence(); err != nil {
+		build.log().Warn("board is offline", "target", board.target, "err", err)
+		setOffline(board.target, err)
+		recordPresence(board.target, err)
+		build.offlineCheckRun(board, err)
 		return
 	}
+	setOffline(board.target, nil)
+
+	run, _ := build.run(board.target)
+	rl := newRunLog(build.sha, board.target, run)
+	defer rl.Close()
+
+	var attempts []*attempt
+	for {
+		a := build.attemptBoardRun(board, rl, len(attempts)+1)
+		attempts = append(attempts, a)
+
+		if !a.kind.retry() || len(attempts) > board.retries {
+			break
+		}
+
+		build.log().Warn("board run failed, retrying", "target", board.target, "attempt", a.number, "kind", a.kind)
+		rl.Printf("=== Attempt %d failed (%s), waiting %s for a fresh reset before retrying", a.number, a.kind, board.resetpause)
+		select {
+		case <-time.After(board.resetpause):
+		case <-build.ctx.Done():
+		}
+
+		// a cancelled build finishes the attempt that it is on, but does
+		// not start another one
+		if reason, cancelled := build.cancelled(); cancelled {
+			rl.Printf("=== Build cancelled, not retrying: %s", reason)
+			break
+		}
+	}
+
+	recordAttempt(board.target, attempts[len(attempts)-1])
+	build.reportBoardRun(board, attempts)
+}
+
+// attempt is a single attempt to flash and test a board.
+type attempt struct {
+	number int
+	kind   failureKind
+	err    error
+
+	// phase and limit are set if the attempt timed out.
+	phase string
+	limit time.Duration
+
+	flash string
+	tests string
+}
+
+// attemptBoardRun flashes the board and then runs the tests once.
+func (build *Build) attemptBoardRun(board *Board, rl *runLog, number int) *attempt {
+	a := &attempt{number: number}
+	logger := build.log().With("target", board.target, "attempt", number)
+
+	logger.Info("flashing board", "phase", "flash")
+	build.setRunState(board.target, RunFlashing)
+	rl.Printf("=== Flashing %s, attempt %d", board.displayname, number)
+	start := time.Now()
+	flashctx, cancel := context.WithTimeout(build.ctx, board.flashtimeout)
+	defer cancel()
+	a.flash, a.err = board.flash(flashctx, build.sha, build.options, rl)
+	phaseDuration.since(start, "flash", board.target)
+	switch {
+	case timedOut(flashctx):
+		a.kind, a.phase, a.limit = failureTimeout, "flash", board.flashtimeout
+	case build.ctx.Err() != nil:
+		a.kind = failureCancelled
+	default:
+		a.kind = classifyFlash(a.flash, a.err)
+	}
+	if a.kind != failureNone {
+		if a.kind != failureCancelled {
+			// a cancelled build says nothing about the board
+			boardFailures.inc(board.target, "flash")
+		}
+		rl.Printf("=== Flash failed (%s): %v", a.kind, a.err)
+		logger.Warn("flash failed", "phase", "flash", "kind", a.kind, "err", a.err)
+		logger.Debug("flash output", "phase", "flash", "output", a.flash)
+		return a
+	}
 
-	build.passCheckRun(board.target, boardHeading(board)+flashout(fout)+testsout(out))
+	logger.Debug("flash output", "phase", "flash", "output", a.flash)
+	markSeen(board.target)
+	logger.Info("waiting for reset", "phase", "reset_wait", "pause", board.resetpause)
+	build.setRunState(board.target, RunResetWait)
+	rl.Printf("=== Waiting %s for reset", board.resetpause)
+	start = time.Now()
+	select {
+	case <-time.After(board.resetpause):
+	case <-build.ctx.Done():
+	}
+	phaseDuration.since(start, "reset_wait", board.target)
+
+	logger.Info("running tests", "phase", "test")
+	build.setRunState(board.target, RunTesting)
+	rl.Printf("=== Running tests")
+	start = time.Now()
+	testctx, cancel := context.WithTimeout(build.ctx, board.testtimeout)
+	defer cancel()
+	a.tests, a.err = board.test(testctx, rl)
+	build.recordTests(board.target, number, a.tests)
+	phaseDuration.since(start, "test", board.target)
+	switch {
+	case timedOut(testctx):
+		a.kind, a.phase, a.limit = failureTimeout, "test run", board.testtimeout
+	case build.ctx.Err() != nil:
+		a.kind = failureCancelled
+	default:
+		a.kind = classifyTests(a.tests, a.err)
+	}
+	if a.kind != failureNone {
+		if a.kind != failureCancelled {
+			boardFailures.inc(board.target, "test")
+		}
+		rl.Printf("=== Tests failed (%s): %v", a.kind, a.err)
+		logger.Warn("tests failed", "phase", "test", "kind", a.kind, "err", a.err)
+	}
+	logger.Debug("test output", "phase", "test", "output", a.tests)
+
+	return a
+}
+
+// reportBoardRun completes the check run for the board, based on how
+// the last attempt went. The output includes every attempt.
+func (build *Build) reportBoardRun(board *Board, attempts []*attempt) {
+	last := attempts[len(attempts)-1]
+
+	var cmp *comparison
+	if last.tests != "" {
+		cmp = build.compare(board.target, last.tests)
+	}
+	output := build.boardReport(board, cmp, attempts)
+
+	tests := parseTAP(last.tests)
+	switch last.kind {
+	case failureNone:
+		build.passCheckRun(board.target, output, tests...)
+	case failureCancelled:
+		reason, ok := build.cancelled()
+		if !ok {
+			// stopped by the server shutting down, so it is run again later
+			build.requeueCheckRun(board.target, interruptedReason)
+			return
+		}
+		build.cancelCheckRun(board.target, reason+"\n\n"+output)
+	case failureTimeout:
+		if last.phase != "" {
+			build.timeoutCheckRun(board.target, last.phase, last.limit, output, tests...)
+			return
+		}
+		build.failCheckRun(board.target, output, tests...)
+	case failureInfra, failureFlash:
+		build.infraCheckRun(board.target, last.kind, output)
+	case failureAssertion:
+		if failOnRegressionsOnly && cmp != nil && !cmp.regressed() {
+			build.knownFailuresCheckRun(board.target, cmp, output, tests...)
+			return
+		}
+		build.failCheckRun(board.target, output, tests...)
+	default:
+		build.failCheckRun(board.target, output, tests...)
+	}
 }
 
 func boardHeading(board *Board) string {
@@ -65,6 +494,14 @@ func boardHeading(board *Board) string {
 	return heading
 }
 
+func attemptHeading(a *attempt) string {
+	result := "passed"
+	if a.kind != failureNone {
+		result = string(a.kind) + " failure"
+	}
+	return "## Attempt " + strconv.Itoa(a.number) + ": " + result + "\n\n"
+}
+
 func flashout(out string) string {
 	return "## Flash\n\n```\n" +
 		out +
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// failureKind is the kind of problem that made a board run fail.
+type failureKind string
+
+const (
+	failureNone failureKind = ""
+
+	// failureInfra is a problem with the test hardware or the server,
+	// such as a missing device or docker not running.
+	failureInfra failureKind = "infrastructure"
+
+	// failureFlash is the flashing tool failing to program the board.
+	failureFlash failureKind = "flash"
+
+	// failureBuild is TinyGo failing to compile or link the tests, or
+	// crashing while it does.
+	failureBuild failureKind = "build"
+
+	// failureCrash is the board crashing while running the tests.
+	failureCrash failureKind = "crash"
+
+	// failureTimeout is a phase taking too long to finish.
+	failureTimeout failureKind = "timeout"
+
+	// failureAssertion is one or more of the tests failing.
+	failureAssertion failureKind = "assertion"
+
+	// failureCancelled is the build being cancelled while it was running.
+	failureCancelled failureKind = "cancelled"
+)
+
+// retry returns true if a failure of this kind should be retried,
+// since it is probably not caused by the commit being tested.
+func (kind failureKind) retry() bool {
+	return kind == failureInfra || kind == failureFlash
+}
+
+var (
+	// errors from the Go compiler, such as "main.go:12:2: undefined: foo"
+	compileError = regexp.MustCompile(`(?m)^\S+\.go:\d+:\d+: `)
+
+	// output that means there is a problem with the test hardware
+	infraPatterns = []string{
+		"cannot connect to the docker daemon",
+		"unable to find image",
+		"device or resource busy",
+		"no device found",
+		"unable to locate a serial port",
+		"failed to find port",
+		"failed to reset port",
+		"serial open error",
+		"serial read error",
+		"libusb",
+	}
+
+	// errors that are only a problem with the test hardware if they are
+	// about a device or docker, since TinyGo fails the same way when a
+	// file is missing from its own tree
+	fileErrorPatterns = []string{
+		"no such file or directory",
+		"permission denied",
+	}
+	devicePatterns = []string{
+		"/dev/",
+		"/media/",
+		"docker",
+	}
+
+	// output from the tools that program the boards, which means that the
+	// tests were built and the board could not be flashed
+	flashPatterns = []string{
+		"bossac",
+		"openocd",
+		"avrdude",
+		"picotool",
+		"esptool",
+		// TinyGo copying a UF2 file to a board in bootloader mode
+		"unable to locate any volume",
+	}
+
+	// output that means the board crashed
+	crashPatterns = []string{
+		"panic:",
+		"fatal error:",
+		"hardfault",
+		"runtime error:",
+	}
+)
+
+// classifyFlash returns the kind of failure from the output and error
+// of flashing a board. Anything that is not a known problem with the
+// hardware or the flashing tool is a build failure, such as a link error
+// or TinyGo crashing, so that it is reported as a failure in TinyGo.
+func classifyFlash(out string, err error) failureKind {
+	switch {
+	case err == nil:
+		return failureNone
+	case errors.Is(err, os.ErrNotExist), isExecError(err):
+		return failureInfra
+	case compileError.MatchString(out):
+		return failureBuild
+	case containsAny(out, infraPatterns), deviceError(out):
+		return failureInfra
+	case containsAny(out, flashPatterns):
+		return failureFlash
+	}
+	return failureBuild
+}
+
+// tapTimeout is printed by the test runner if the board stops sending TAP
+// output. The deadline for the whole test run is a failureTimeout instead.
+const tapTimeout = "Timeout waiting for TAP output"
+
+// classifyTests returns the kind of failure from the output and error
+// of the test runner.
+func classifyTests(out string, err error) failureKind {
+	switch {
+	case err == nil:
+		return failureNone
+	case errors.Is(err, os.ErrNotExist), isExecError(err):
+		return failureInfra
+	case containsAny(out, infraPatterns), deviceError(out):
+		return failureInfra
+	case containsAny(out, crashPatterns):
+		return failureCrash
+	case hasFailedTest(out):
+		return failureAssertion
+	case strings.Contains(out, tapTimeout) && len(parseTAP(out)) == 0:
+		// the board never started the tests, such as when it missed the
+		// prompt, which is retried. A board that stops partway through
+		// the tests is a crash.
+		return failureInfra
+	}
+	return failureCrash
+}
+
+// isExecError returns true if the command could not be started at all.
+func isExecError(err error) bool {
+	var eerr *exec.Error
+	return errors.As(err, &eerr)
+}
+
+// deviceError returns true if a line of the output is an error opening a
+// device, such as the serial port of a board, or an error from docker.
+func deviceError(out string) bool {
+	for _, line := range strings.Split(out, "\n") {
+		if containsAny(line, fileErrorPatterns) && containsAny(line, devicePatterns) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFailedTest returns true if the TAP output has a test that failed.
+func hasFailedTest(out string) bool {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "not ok") &&
+			!strings.Contains(line, "# TODO") &&
+			!strings.Contains(line, "# SKIP") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny returns true if s contains any of the patterns, ignoring case.
+func containsAny(s string, patterns []string) bool {
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestClassifyFlash(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	tests := []struct {
+		name string
+		out  string
+		err  error
+		want failureKind
+	}{
+		{name: "success", out: "[=====] 100% (24/24 pages)", want: failureNone},
+		{name: "missing binary", err: fmt.Errorf("flash: %w", os.ErrNotExist), want: failureInfra},
+		{name: "not started", err: &exec.Error{Name: "docker", Err: exec.ErrNotFound}, want: failureInfra},
+		{name: "compile error", out: "main.go:12:2: undefined: foo", err: exitErr, want: failureBuild},
+		{name: "compile error mentioning flash tool", out: "bossac.go:3:1: syntax error", err: exitErr, want: failureBuild},
+		{name: "docker not running", out: "Cannot connect to the Docker daemon at unix:///var/run/docker.sock", err: exitErr, want: failureInfra},
+		{name: "docker socket", out: "docker: permission denied while trying to connect to the Docker daemon socket", err: exitErr, want: failureInfra},
+		{name: "device missing", out: "error: failed to flash: open /dev/ttyACM0: no such file or directory", err: exitErr, want: failureInfra},
+		{name: "uf2 volume missing", out: "error: failed to flash: open /media/RPI-RP2/flash.uf2: permission denied", err: exitErr, want: failureInfra},
+		{name: "tinygo file missing", out: "error: open /usr/local/tinygo/lib/picolibc/newlib/libc/string/memcpy.c: no such file or directory", err: exitErr, want: failureBuild},
+		{name: "tinygo file not readable", out: "error: could not read /usr/local/tinygo/src/runtime/runtime.go: permission denied", err: exitErr, want: failureBuild},
+		{name: "port busy", out: "Device or resource busy", err: exitErr, want: failureInfra},
+		{name: "bossac", out: "error: failed to flash: bossac: No device found", err: exitErr, want: failureInfra},
+		{name: "bossac failed", out: "bossac: SAM-BA operation failed", err: exitErr, want: failureFlash},
+		{name: "openocd", out: "Error: open failed\nin procedure 'program'\nopenocd: exit status 1", err: exitErr, want: failureFlash},
+		{name: "avrdude", out: "avrdude: stk500_recv(): programmer is not responding", err: exitErr, want: failureFlash},
+		{name: "picotool", out: "error: failed to flash: picotool: the device is not in BOOTSEL mode", err: exitErr, want: failureFlash},
+		{name: "esptool", out: "esptool.py v4.5\nA fatal error occurred: Failed to connect", err: exitErr, want: failureFlash},
+		{name: "uf2 volume", out: "error: failed to flash: unable to locate any volume: [PICOBOOT]", err: exitErr, want: failureFlash},
+		{name: "link error", out: "error: ld.lld: error: section '.text' will not fit in region 'FLASH_TEXT'", err: exitErr, want: failureBuild},
+		{name: "tinygo crash", out: "panic: runtime error: index out of range\n\ngoroutine 1 [running]:", err: exitErr, want: failureBuild},
+		{name: "no output", err: exitErr, want: failureBuild},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFlash(tt.out, tt.err); got != tt.want {
+				t.Errorf("classifyFlash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyTests(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	tests := []struct {
+		name string
+		out  string
+		err  error
+		want failureKind
+	}{
+		{name: "success", out: "TAP version 13\nok 1 - digital\n1..1", want: failureNone},
+		{name: "missing runner", err: os.ErrNotExist, want: failureInfra},
+		{name: "not started", err: &exec.Error{Name: "testrunner", Err: exec.ErrNotFound}, want: failureInfra},
+		{name: "serial port", out: "serial open error: no such file or directory", err: exitErr, want: failureInfra},
+		{name: "serial device", out: "open /dev/ttyACM0: permission denied", err: exitErr, want: failureInfra},
+		{name: "panic", out: "TAP version 13\nok 1 - digital\npanic: nil pointer dereference", err: exitErr, want: failureCrash},
+		{name: "hardfault", out: "TAP version 13\n[tinygo: HardFault at 0x00000000]", err: exitErr, want: failureCrash},
+		{name: "failed test", out: "TAP version 13\nok 1 - digital\nnot ok 2 - i2c\n1..2", err: exitErr, want: failureAssertion},
+		{name: "indented failed test", out: "TAP version 13\n  not ok 1 - adc", err: exitErr, want: failureAssertion},
+		{name: "todo and skip", out: "TAP version 13\nnot ok 1 - spi # TODO\nnot ok 2 - pwm # SKIP no pin", err: exitErr, want: failureCrash},
+		{name: "no tap output", out: "Timeout waiting for TAP output", err: exitErr, want: failureInfra},
+		{name: "missed prompt", out: "Timeout waiting for device prompt. trying anyhow...\nTimeout waiting for TAP output", err: exitErr, want: failureInfra},
+		{name: "crashed before tap output", out: "panic: out of memory\nTimeout waiting for TAP output", err: exitErr, want: failureCrash},
+		{name: "stopped partway", out: "TAP version 13\nok 1 - digital # time=5ms\nTimeout waiting for TAP output", err: exitErr, want: failureCrash},
+		{name: "unknown", out: "TAP version 13\nok 1 - digital", err: exitErr, want: failureCrash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyTests(tt.out, tt.err); got != tt.want {
+				t.Errorf("classifyTests() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureKindRetry(t *testing.T) {
+	tests := []struct {
+		kind failureKind
+		want bool
+	}{
+		{failureNone, false},
+		{failureInfra, true},
+		{failureFlash, true},
+		{failureBuild, false},
+		{failureCrash, false},
+		{failureTimeout, false},
+		{failureAssertion, false},
+		{failureCancelled, false},
+	}
+	for _, tt := range tests {
+		if got := tt.kind.retry(); got != tt.want {
+			t.Errorf("%q.retry() = %v, want %v", tt.kind, got, tt.want)
+		}
+	}
+}
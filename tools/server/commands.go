@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+)
+
+// commandPrefix starts the line of a pull request comment that is a
+// command for TinyHCI, such as "/tinyhci retest pico".
+const commandPrefix = "/tinyhci"
+
+const commandUsage = "TinyHCI commands, one in each comment:\n\n" +
+	"- `/tinyhci retest` runs all of the enabled boards again\n" +
+	"- `/tinyhci retest <board> ...` runs the boards again, even if they are disabled or quarantined\n" +
+	"- `/tinyhci run disabled-boards` runs the boards that are disabled or quarantined\n" +
+	"- `/tinyhci run all-boards` runs every board, including the ones that are disabled or quarantined\n" +
+	"- `/tinyhci run extended [<board> ...]` runs the boards, or all of the enabled boards, with extended diagnostics\n" +
+	"- `/tinyhci cancel` cancels the build that is running\n" +
+	"- `/tinyhci help` shows this message\n"
+
+// errHelp is returned by parseComment if the comment asks for help.
+var errHelp = errors.New("help")
+
+// command is a single command from a pull request comment.
+type command struct {
+	// line is the command as it was written.
+	line string
+
+	cancel  bool
+	targets []string
+	opts    runOptions
+}
+
+// parseComment returns the command in the comment, or nil if there is
+// none. Lines that do not start with /tinyhci are ignored, including
+// quotes of other comments. Each comment can only have one command, since
+// each command starts a build for the commit.
+func parseComment(body string) (*command, error) {
+	var lines [][]string
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == commandPrefix {
+			lines = append(lines, fields)
+		}
+	}
+	switch {
+	case len(lines) == 0:
+		return nil, nil
+	case len(lines) > 1:
+		return nil, errors.New("only one command can be run from each comment")
+	}
+
+	cmd, err := parseCommand(lines[0][1:])
+	if err != nil {
+		return nil, err
+	}
+	cmd.line = strings.Join(lines[0], " ")
+	return &cmd, nil
+}
+
+func parseCommand(args []string) (command, error) {
+	if len(args) == 0 {
+		return command{}, errHelp
+	}
+
+	var cmd command
+	switch args[0] {
+	case "help":
+		return command{}, errHelp
+	case "cancel":
+		if len(args) > 1 {
+			return command{}, errors.New("`cancel` does not take any boards")
+		}
+		cmd.cancel = true
+	case "retest":
+		boards, err := namedTargets(args[1:])
+		if err != nil {
+			return command{}, err
+		}
+		cmd.targets = boards
+		cmd.opts.allBoards = len(boards) > 0
+	case "run":
+		if len(args) < 2 {
+			return command{}, errors.New("`run` needs `disabled-boards`, `all-boards` or `extended`")
+		}
+		switch args[1] {
+		case "disabled-boards":
+			cmd.targets = disabledTargets()
+			if len(cmd.targets) == 0 {
+				return command{}, errors.New("there are no disabled or quarantined boards")
+			}
+			cmd.opts.allBoards = true
+		case "all-boards":
+			for _, board := range Boards() {
+				cmd.targets = append(cmd.targets, board.target)
+			}
+			cmd.opts.allBoards = true
+		case "extended":
+			// the same diagnostics as the "Re-run verbose" button
+			boards, err := namedTargets(args[2:])
+			if err != nil {
+				return command{}, err
+			}
+			cmd.targets = boards
+			cmd.opts.allBoards = len(boards) > 0
+			cmd.opts.verbose = true
+		default:
+			return command{}, fmt.Errorf("unknown run `%s`", args[1])
+		}
+		if args[1] != "extended" && len(args) > 2 {
+			return command{}, fmt.Errorf("`run %s` does not take any boards", args[1])
+		}
+	default:
+		return command{}, fmt.Errorf("unknown command `%s`", args[0])
+	}
+
+	if !cmd.cancel && len(cmd.targets) == 0 {
+		cmd.targets = enabledTargets()
+	}
+	return cmd, nil
+}
+
+// namedTargets checks that there is a board for each of the targets.
+func namedTargets(targets []string) ([]string, error) {
+	for _, target := range targets {
+		if GetBoard(target) == nil {
+			return nil, fmt.Errorf("unknown board `%s`", target)
+		}
+	}
+	return targets, nil
+}
+
+// disabledTargets returns the targets of all of the boards that are
+// disabled or quarantined.
+func disabledTargets() []string {
+	var targets []string
+	for _, board := range Boards() {
+		if _, ok := quarantined(board.target); ok || !board.enabled {
+			targets = append(targets, board.target)
+		}
+	}
+	return targets
+}
+
+// handleComment runs the command in a new comment on a pull request from
+// a user with write access to the repo. The comment gets a 👍 reaction
+// once the command has been accepted, or a reply if it could not be.
+func handleComment(event *github.IssueCommentEvent, buildsCh chan *Build) {
+	comment := event.GetComment()
+	if event.GetAction() != "created" || !event.GetIssue().IsPullRequest() ||
+		comment.GetUser().GetType() == "Bot" {
+		return
+	}
+
+	cmd, err := parseComment(comment.GetBody())
+	if cmd == nil && err == nil {
+		return
+	}
+
+	number := event.GetIssue().GetNumber()
+	user := comment.GetUser().GetLogin()
+	logger := slog.With("pr", number, "user", user)
+
+	reply := func(reaction, message string) {
+		if err := reactToComment(comment.GetID(), reaction); err != nil {
+			logger.Error("unable to react to comment", "err", err)
+		}
+		if message == "" {
+			return
+		}
+		if err := commentOnIssue(number, "@"+user+" "+message); err != nil {
+			logger.Error("unable to reply to comment", "err", err)
+		}
+	}
+
+	ok, perr := hasWriteAccess(user)
+	switch {
+	case perr != nil:
+		logger.Error("unable to check permission", "err", perr)
+		reply("confused", "TinyHCI could not check your permission to run commands. Please try again later.")
+		return
+	case !ok:
+		logger.Warn("ignoring command from user without write access")
+		reply("-1", "only people with write access to this repo can run TinyHCI commands.")
+		return
+	case errors.Is(err, errHelp):
+		reply("eyes", commandUsage)
+		return
+	case err != nil:
+		reply("confused", "TinyHCI did not understand that: "+err.Error()+".\n\n"+commandUsage)
+		return
+	}
+
+	pr, err := getPullRequest(number)
+	if err != nil {
+		logger.Error("unable to get pull request", "err", err)
+		reply("confused", "TinyHCI could not find the commit for this pull request.")
+		return
+	}
+	sha := pr.GetHead().GetSHA()
+
+	logger.Info("running comment command", "sha", sha, "command", cmd.line)
+	if err := cmd.run(sha, user, buildsCh); err != nil {
+		logger.Warn("comment command failed", "sha", sha, "command", cmd.line, "err", err)
+		reply("confused", "`"+cmd.line+"` failed: "+err.Error()+".")
+		return
+	}
+	reply("+1", "")
+}
+
+// run runs the command for the commit.
+func (cmd command) run(sha, user string, buildsCh chan *Build) error {
+	if !cmd.cancel {
+		return rerun(sha, cmd.targets, cmd.opts, buildsCh)
+	}
+
+	build, ok := builds.get(sha)
+	if !ok || build.getState().finished() {
+		return errors.New("there is no build for " + sha[:7] + " to cancel")
+	}
+	build.cancel("Cancelled by @"+user+".", true)
+	return nil
+}
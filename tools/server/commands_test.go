@@ -0,0 +1,169 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// useBoards configures the boards for the test, without starting workers.
+func useBoards(t *testing.T, newboards ...*Board) {
+	boardsMu.Lock()
+	prev := boards
+	boards = newboards
+	boardsMu.Unlock()
+
+	t.Cleanup(func() {
+		boardsMu.Lock()
+		boards = prev
+		boardsMu.Unlock()
+	})
+}
+
+func TestParseComment(t *testing.T) {
+	useBoards(t,
+		&Board{target: "pico", enabled: true},
+		&Board{target: "microbit", enabled: true},
+		&Board{target: "maixbit", enabled: false},
+	)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    *command
+		wantErr string
+	}{
+		{
+			name: "no command",
+			body: "Looks good to me.",
+		},
+		{
+			name: "not at the start of the line",
+			body: "Try /tinyhci retest",
+		},
+		{
+			name: "quoted",
+			body: "> /tinyhci retest\n\nThat already ran.",
+		},
+		{
+			name: "other prefix",
+			body: "/tinyhcix retest",
+		},
+		{
+			name: "retest",
+			body: "/tinyhci retest",
+			want: &command{line: "/tinyhci retest", targets: []string{"pico", "microbit"}},
+		},
+		{
+			name: "retest boards",
+			body: "Flaky, trying again.\n/tinyhci   retest maixbit pico\r\n",
+			want: &command{line: "/tinyhci retest maixbit pico", targets: []string{"maixbit", "pico"}, opts: runOptions{allBoards: true}},
+		},
+		{
+			name:    "retest unknown board",
+			body:    "/tinyhci retest pico arduino",
+			wantErr: "unknown board `arduino`",
+		},
+		{
+			name: "run disabled boards",
+			body: "/tinyhci run disabled-boards",
+			want: &command{line: "/tinyhci run disabled-boards", targets: []string{"maixbit"}, opts: runOptions{allBoards: true}},
+		},
+		{
+			name: "run all boards",
+			body: "/tinyhci run all-boards",
+			want: &command{line: "/tinyhci run all-boards", targets: []string{"pico", "microbit", "maixbit"}, opts: runOptions{allBoards: true}},
+		},
+		{
+			name:    "run with boards",
+			body:    "/tinyhci run all-boards pico",
+			wantErr: "`run all-boards` does not take any boards",
+		},
+		{
+			name:    "run nothing",
+			body:    "/tinyhci run",
+			wantErr: "`run` needs `disabled-boards`, `all-boards` or `extended`",
+		},
+		{
+			name: "run extended",
+			body: "/tinyhci run extended",
+			want: &command{line: "/tinyhci run extended", targets: []string{"pico", "microbit"}, opts: runOptions{verbose: true}},
+		},
+		{
+			name: "run extended boards",
+			body: "/tinyhci run extended maixbit",
+			want: &command{line: "/tinyhci run extended maixbit", targets: []string{"maixbit"}, opts: runOptions{verbose: true, allBoards: true}},
+		},
+		{
+			name:    "run extended unknown board",
+			body:    "/tinyhci run extended arduino",
+			wantErr: "unknown board `arduino`",
+		},
+		{
+			name:    "run unknown",
+			body:    "/tinyhci run everything",
+			wantErr: "unknown run `everything`",
+		},
+		{
+			name: "cancel",
+			body: "/tinyhci cancel",
+			want: &command{line: "/tinyhci cancel", cancel: true},
+		},
+		{
+			name:    "cancel boards",
+			body:    "/tinyhci cancel pico",
+			wantErr: "`cancel` does not take any boards",
+		},
+		{
+			name:    "help",
+			body:    "/tinyhci help",
+			wantErr: errHelp.Error(),
+		},
+		{
+			name:    "no arguments",
+			body:    "/tinyhci",
+			wantErr: errHelp.Error(),
+		},
+		{
+			name:    "unknown command",
+			body:    "/tinyhci deploy",
+			wantErr: "unknown command `deploy`",
+		},
+		{
+			name:    "more than one command",
+			body:    "/tinyhci retest pico\n/tinyhci cancel",
+			wantErr: "only one command can be run from each comment",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseComment(tt.body)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("parseComment() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseComment() returned error: %v", err)
+			}
+
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil:
+				t.Errorf("parseComment() = %+v, want %+v", got, tt.want)
+			case got.line != tt.want.line || got.cancel != tt.want.cancel ||
+				!slices.Equal(got.targets, tt.want.targets) || got.opts != tt.want.opts:
+				t.Errorf("parseComment() = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommentNoDisabledBoards(t *testing.T) {
+	useBoards(t, &Board{target: "pico", enabled: true})
+
+	_, err := parseComment("/tinyhci run disabled-boards")
+	if want := "there are no disabled or quarantined boards"; err == nil || err.Error() != want {
+		t.Errorf("parseComment() error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// how many completed builds to show on the dashboard
+const dashboardRecentBuilds = 20
+
+// dashboardData is everything shown on the dashboard.
+type dashboardData struct {
+	Org    string
+	Repo   string
+	Queue  []storedBuild
+	Boards []dashboardBoard
+	Recent []storedBuild
+	Flaky  []flakyTest
+}
+
+// dashboardBoard is a board and its current status.
+type dashboardBoard struct {
+	Target      string
+	DisplayName string
+	Enabled     bool
+	Status      boardStatus
+}
+
+// handleDashboard shows the build queue, the boards and the most
+// recent builds.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	data := dashboardData{
+		Org:  ghorg,
+		Repo: ghrepo,
+	}
+
+	for _, sb := range store.list() {
+		if sb.finished() {
+			if len(data.Recent) < dashboardRecentBuilds {
+				data.Recent = append(data.Recent, sb)
+			}
+			continue
+		}
+		data.Queue = append(data.Queue, sb)
+	}
+
+	// oldest first, which is the order they will run in
+	sort.Slice(data.Queue, func(i, j int) bool {
+		return data.Queue[i].Started.Before(data.Queue[j].Started)
+	})
+
+	for _, board := range Boards() {
+		data.Boards = append(data.Boards, dashboardBoard{
+			Target:      board.target,
+			DisplayName: board.displayname,
+			Enabled:     board.enabled,
+			Status:      getStatus(board.target),
+		})
+	}
+
+	if testResults != nil {
+		data.Flaky = testResults.flakyTests()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		slog.Error("unable to show dashboard", "err", err)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"short": func(sha string) string {
+		if len(sha) < 7 {
+			return sha
+		}
+		return sha[:7]
+	},
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>TinyHCI</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.success { color: green; }
+.failure, .timed_out { color: red; }
+.disabled { color: #999; }
+</style>
+</head>
+<body>
+<h1>TinyHCI for {{.Org}}/{{.Repo}}</h1>
+
+<h2>Boards</h2>
+<table>
+<tr><th>Board</th><th>Enabled</th><th>Current</th><th>Last result</th><th>Last seen</th></tr>
+{{range .Boards}}
+<tr{{if not .Enabled}} class="disabled"{{end}}>
+<td>{{.DisplayName}} ({{.Target}})</td>
+<td>{{.Enabled}}</td>
+<td>{{if .Status.Quarantined}}<span class="failure">quarantined {{ago .Status.QuarantinedSince}}: {{.Status.Quarantined}}</span>{{else if .Status.Offline}}<span class="failure">offline: {{.Status.Offline}}</span>{{else if .Status.Phase}}<a href="/logs/{{.Status.SHA}}/{{.Target}}">{{.Status.Phase}} {{short .Status.SHA}}</a>, started {{ago .Status.Since}}{{else}}idle{{end}}</td>
+<td class="{{.Status.LastResult}}">{{if .Status.LastResult}}{{.Status.LastResult}} on {{short .Status.LastSHA}}{{end}}</td>
+<td>{{ago .Status.LastSeen}}</td>
+</tr>
+{{end}}
+</table>
+
+<h2>Queue</h2>
+{{if .Queue}}
+<table>
+<tr><th>Commit</th><th>State</th><th>Received</th><th>Boards remaining</th></tr>
+{{range .Queue}}
+<tr>
+<td><a href="https://github.com/{{$.Org}}/{{$.Repo}}/commit/{{.SHA}}">{{short .SHA}}</a></td>
+<td>{{.State}}</td>
+<td>{{ago .Started}}</td>
+<td>{{range $target, $id := .Runs}}<a href="https://github.com/{{$.Org}}/{{$.Repo}}/runs/{{$id}}">{{$target}}</a> {{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No builds queued.</p>
+{{end}}
+
+<h2>Recent builds</h2>
+<table>
+<tr><th>Commit</th><th>Completed</th><th>Results</th></tr>
+{{range .Recent}}
+<tr>
+<td><a href="https://github.com/{{$.Org}}/{{$.Repo}}/commit/{{.SHA}}">{{short .SHA}}</a></td>
+<td>{{ago .Completed}}</td>
+<td>{{range $target, $r := .Results}}<a class="{{$r.Conclusion}}" href="https://github.com/{{$.Org}}/{{$.Repo}}/runs/{{$r.ID}}">{{$target}}</a> {{end}}</td>
+</tr>
+{{end}}
+</table>
+
+{{if .Flaky}}
+<h2>Flaky tests</h2>
+<table>
+<tr><th>Board</th><th>Test</th><th>Detected</th></tr>
+{{range .Flaky}}
+<tr>
+<td>{{.Board}}</td>
+<td>{{.Test}}</td>
+<td><a href="https://github.com/{{$.Org}}/{{$.Repo}}/commit/{{.SHA}}">{{short .SHA}}</a>, {{ago .Detected}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -3,8 +3,6 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -23,120 +21,87 @@ func authenticateGithubClient(appid, installid int64, privatekeyfile string) (*g
 	return github.NewClient(&http.Client{Transport: itr}), nil
 }
 
-func (build Build) pendingCheckSuite() {
-	log.Printf("Github check suite pending for %s\n", build.sha)
-	for _, board := range boards {
-		if board.enabled {
-			build.pendingCheckRun(board.target)
-		}
-	}
-}
+// githubReporter reports results using GitHub check runs.
+type githubReporter struct{}
 
-func (build Build) pendingCheckRun(target string) {
-	log.Printf("Github check run pending on board %s for %s\n", target, build.sha)
+func (githubReporter) Pending(build *Build, target string) (int64, error) {
 	opts := github.CreateCheckRunOptions{
 		Name:    targetName(target),
 		HeadSHA: build.sha,
 	}
+	// the ID of the check run is not known yet, so this links to the
+	// latest run of the board until the run starts
+	if url := runLogURL(build.sha, target, 0); url != "" {
+		opts.DetailsURL = &url
+	}
 	cr, _, err := client.Checks.CreateCheckRun(context.Background(), ghorg, ghrepo, opts)
 	if err != nil {
-		log.Println(err)
+		return 0, err
 	}
-	build.runs[target] = cr
+	return cr.GetID(), nil
 }
 
-func (build Build) startCheckSuite() {
-	log.Printf("Github check suite starting for %s\n", build.sha)
-	for _, run := range build.runs {
-		target, _ := parseTarget(run.GetName())
-		build.startCheckRun(target)
-	}
-}
-
-func (build Build) startCheckRun(target string) {
-	log.Printf("Github check run starting on board %s for %s\n", target, build.sha)
+func (githubReporter) Start(build *Build, target string, id int64) error {
 	status := "in_progress"
-	if run, ok := build.runs[target]; ok {
-		opts := github.UpdateCheckRunOptions{
-			Name:   targetName(target),
-			Status: &status,
-		}
-		cr, _, err := client.Checks.UpdateCheckRun(context.Background(), ghorg, ghrepo, *run.ID, opts)
-		if err != nil {
-			log.Println(err)
-		}
-		build.runs[target] = cr
+	opts := github.UpdateCheckRunOptions{
+		Name:   targetName(target),
+		Status: &status,
 	}
-}
-
-func (build Build) passCheckRun(target, output string) {
-	log.Printf("Github check run passed on board %s for %s\n", target, build.sha)
-	title := "Hardware CI passed"
-	summary := "Hardware CI tests have passed."
-	status := "completed"
-	conclusion := "success"
-	timestamp := github.Timestamp{Time: time.Now()}
-	if run, ok := build.runs[target]; ok {
-		ro := github.CheckRunOutput{
-			Title:   &title,
-			Summary: &summary,
-			Text:    &output,
-		}
-
-		opts := github.UpdateCheckRunOptions{
-			Name:        targetName(target),
-			Status:      &status,
-			Conclusion:  &conclusion,
-			CompletedAt: &timestamp,
-			Output:      &ro,
-		}
-		_, _, err := client.Checks.UpdateCheckRun(context.Background(), ghorg, ghrepo, *run.ID, opts)
-		if err != nil {
-			log.Println(err)
-		}
-		delete(build.runs, target)
+	if url := runLogURL(build.sha, target, id); url != "" {
+		opts.DetailsURL = &url
 	}
+	_, _, err := client.Checks.UpdateCheckRun(context.Background(), ghorg, ghrepo, id, opts)
+	return err
 }
 
-func (build Build) failCheckSuite(output string) {
-	log.Printf("Github check suite failed for %s\n", build.sha)
-	for _, run := range build.runs {
-		target, _ := parseTarget(run.GetName())
-		build.failCheckRun(target, output)
+func (githubReporter) Requeue(build *Build, target string, id int64, reason string) error {
+	status := "queued"
+	title := "Hardware CI requeued"
+	opts := github.UpdateCheckRunOptions{
+		Name:   targetName(target),
+		Status: &status,
+		Output: &github.CheckRunOutput{
+			Title:   &title,
+			Summary: &reason,
+		},
 	}
+	_, _, err := client.Checks.UpdateCheckRun(context.Background(), ghorg, ghrepo, id, opts)
+	return err
 }
 
-func (build Build) failCheckRun(target, output string) {
-	log.Printf("Github check run failed on board %s for %s\n", target, build.sha)
-	title := "Hardware CI failed"
-	summary := "Hardware CI tests have failed."
+func (githubReporter) Complete(build *Build, target string, id int64, result Result) error {
 	status := "completed"
-	conclusion := "failure"
 	timestamp := github.Timestamp{Time: time.Now()}
-	if run, ok := build.runs[target]; ok {
-		ro := github.CheckRunOutput{
-			Title:   &title,
-			Summary: &summary,
-			Text:    &output,
-		}
-
-		opts := github.UpdateCheckRunOptions{
-			Name:        targetName(target),
-			Status:      &status,
-			Conclusion:  &conclusion,
-			CompletedAt: &timestamp,
-			Output:      &ro,
-		}
-		_, _, err := client.Checks.UpdateCheckRun(context.Background(), ghorg, ghrepo, *run.ID, opts)
-		if err != nil {
-			log.Println(err)
-		}
-		delete(build.runs, target)
-	}
+	ro := github.CheckRunOutput{
+		Title:   &result.Title,
+		Summary: &result.Summary,
+		Text:    &result.Text,
+	}
+	for _, a := range result.Annotations {
+		ro.Annotations = append(ro.Annotations, &github.CheckRunAnnotation{
+			Path:            github.Ptr(a.Path),
+			StartLine:       github.Ptr(a.Line),
+			EndLine:         github.Ptr(a.Line),
+			AnnotationLevel: github.Ptr(a.Level),
+			Title:           github.Ptr(a.Title),
+			Message:         github.Ptr(a.Message),
+		})
+	}
+
+	opts := github.UpdateCheckRunOptions{
+		Name:        targetName(target),
+		Status:      &status,
+		Conclusion:  &result.Conclusion,
+		CompletedAt: &timestamp,
+		Output:      &ro,
+		Actions:     checkRunActions,
+	}
+	_, _, err := client.Checks.UpdateCheckRun(context.Background(), ghorg, ghrepo, id, opts)
+	return err
 }
 
 // reload the check runs from github for this build
-func (build Build) reloadCheckRuns() error {
+func (build *Build) reloadCheckRuns() error {
 	opts := github.ListCheckRunsOptions{}
 	res, _, err := client.Checks.ListCheckRunsForRef(context.Background(), ghorg, ghrepo, build.sha, &opts)
 	if err != nil {
@@ -152,33 +117,12 @@ func (build Build) reloadCheckRuns() error {
 		if err != nil {
 			return err
 		}
-		build.runs[target] = run
+		build.setRun(target, run.GetID())
 	}
 
 	return nil
 }
 
-// reload check runs from github for this build
-func findCheckRuns(sha, status string) ([]*github.CheckRun, error) {
-	runs := make([]*github.CheckRun, 0)
-	opts := github.ListCheckRunsOptions{Status: &status}
-	res, _, err := client.Checks.ListCheckRunsForRef(context.Background(), ghorg, ghrepo, sha, &opts)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, run := range res.CheckRuns {
-		if !strings.Contains(run.GetName(), "tinyhci:") {
-			continue
-		}
-
-		fmt.Println(run.GetID(), run.GetStatus())
-		runs = append(runs, run)
-	}
-
-	return runs, nil
-}
-
 func targetName(target string) string {
 	return "tinyhci: " + target
 }
@@ -221,10 +165,12 @@ func getTinygoBinaryURLFromGH(runID int64) (string, error) {
 	return "", errors.New("no tinygo linux-amd64 artifact found")
 }
 
-func getRecentSuccessfulWorkflowRuns() ([]*github.WorkflowRun, error) {
-	builds := make([]*github.WorkflowRun, 0)
-
-	opts := github.ListWorkflowRunsOptions{Status: "success"}
+func getRecentWorkflowRunForSHA(status, sha string) (*github.WorkflowRun, error) {
+	opts := github.ListWorkflowRunsOptions{
+		Status:      status,
+		HeadSHA:     sha,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
 	runs, _, err := client.Actions.ListRepositoryWorkflowRuns(context.Background(), ghorg, ghrepo, &opts)
 	if err != nil {
 		return nil, err
@@ -242,40 +188,59 @@ func getRecentSuccessfulWorkflowRuns() ([]*github.WorkflowRun, error) {
 		}
 
 		for _, job := range jobs.Jobs {
-			if job.GetName() == "build-linux" {
-				builds = append(builds, run)
-				continue
+			if job.GetName() == "build-linux" && job.GetHeadSHA() == sha {
+				return run, nil
 			}
 		}
 	}
 
-	return builds, nil
+	return nil, errors.New("no successful workflow found for sha " + sha)
 }
 
-func getRecentWorkflowRunForSHA(status, sha string) (*github.WorkflowRun, error) {
-	opts := github.ListWorkflowRunsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+// workflowInProgress returns true if the Linux CI workflow for the sha has
+// been queued or is running, so that a workflow run event will follow.
+func workflowInProgress(sha string) (bool, error) {
+	opts := github.ListWorkflowRunsOptions{HeadSHA: sha}
 	runs, _, err := client.Actions.ListRepositoryWorkflowRuns(context.Background(), ghorg, ghrepo, &opts)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
 	for _, run := range runs.WorkflowRuns {
-		if run.GetName() != "Linux" {
-			continue
+		if run.GetName() == "Linux" && run.GetStatus() != "completed" {
+			return true, nil
 		}
+	}
+	return false, nil
+}
 
-		opts := github.ListWorkflowJobsOptions{}
-		jobs, _, err := client.Actions.ListWorkflowJobs(context.Background(), ghorg, ghrepo, run.GetID(), &opts)
-		if err != nil {
-			return nil, err
-		}
+// hasWriteAccess returns true if the user can push to the repo.
+func hasWriteAccess(user string) (bool, error) {
+	level, _, err := client.Repositories.GetPermissionLevel(context.Background(), ghorg, ghrepo, user)
+	if err != nil {
+		return false, err
+	}
 
-		for _, job := range jobs.Jobs {
-			if job.GetName() == "build-linux" && job.GetHeadSHA() == sha {
-				return run, nil
-			}
-		}
+	switch level.GetPermission() {
+	case "admin", "maintain", "write":
+		return true, nil
 	}
+	return false, nil
+}
 
-	return nil, errors.New("no successful workflow found for sha " + sha)
+func getPullRequest(number int) (*github.PullRequest, error) {
+	pr, _, err := client.PullRequests.Get(context.Background(), ghorg, ghrepo, number)
+	return pr, err
+}
+
+// reactToComment adds a reaction such as "+1" to the comment on an issue or pull request.
+func reactToComment(id int64, reaction string) error {
+	_, _, err := client.Reactions.CreateIssueCommentReaction(context.Background(), ghorg, ghrepo, id, reaction)
+	return err
+}
+
+// commentOnIssue adds a comment to the issue or pull request.
+func commentOnIssue(number int, body string) error {
+	_, _, err := client.Issues.CreateComment(context.Background(), ghorg, ghrepo, number, &github.IssueComment{Body: &body})
+	return err
 }
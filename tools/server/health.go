@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+const (
+	// how often idle boards are checked
+	defaultHealthInterval = 5 * time.Minute
+
+	// how many infrastructure failures in a row take a board out of service
+	defaultQuarantineAfter = 3
+
+	// how long a smoke flash may take
+	smokeFlashTimeout = 3 * time.Minute
+)
+
+var (
+	healthInterval  = defaultHealthInterval
+	quarantineAfter = defaultQuarantineAfter
+)
+
+// monitorHealth checks each enabled board that is idle at every interval,
+// so that a board that has gone offline is noticed between builds, and a
+// quarantined board returns to service once it is shown to work again.
+func monitorHealth() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if shuttingDown() {
+			return
+		}
+
+		for _, board := range Boards() {
+			if board.enabled {
+				probeBoard(board)
+			}
+		}
+	}
+}
+
+// probeBoard checks the board, unless it is being used for a build.
+// The board must be present, and if it has had infrastructure failures
+// and has smoke flashing enabled, it must flash the last TinyGo build
+// that ran on it. Without a smoke flash, a board that failed for any
+// reason other than being offline stays quarantined.
+func probeBoard(board *Board) {
+	w, ok := getWorker(board.target)
+	if !ok || !w.device.TryLock() {
+		return
+	}
+	defer w.device.Unlock()
+
+	err := board.checkPresence()
+	setOffline(board.target, err)
+	if err != nil {
+		slog.Warn("board health check failed", "target", board.target, "err", err)
+		recordPresence(board.target, err)
+		return
+	}
+
+	status := getStatus(board.target)
+	if !board.smokeflash || status.InfraFailures == 0 || status.LastSHA == "" {
+		recordPresence(board.target, nil)
+		return
+	}
+
+	err = board.smokeFlash(status.LastSHA)
+	if err != nil {
+		slog.Warn("board health check failed", "target", board.target, "err", err)
+	}
+	recordHealth(board.target, err)
+}
+
+// smokeFlash flashes the board with the tests for an earlier build, to check
+// that the board can still be flashed.
+func (board *Board) smokeFlash(sha string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), smokeFlashTimeout)
+	defer cancel()
+
+	out, err := board.flash(ctx, sha, runOptions{}, io.Discard)
+	if kind := classifyFlash(out, err); kind != failureNone {
+		return fmt.Errorf("smoke flash failed (%s): %v", kind, err)
+	}
+	return nil
+}
+
+// recordHealth records whether the board could be flashed, which it could
+// if err is nil. A board is quarantined once it has failed quarantineAfter
+// times in a row because of the hardware, and returns to service once it
+// works again.
+func recordHealth(target string, err error) {
+	updateHealth(target, err, false)
+}
+
+// recordPresence records whether the board was found, which it was if err
+// is nil. Being found says nothing about whether the board can be flashed,
+// so it only returns a board to service if all of its failures were
+// because it was offline.
+func recordPresence(target string, err error) {
+	updateHealth(target, err, true)
+}
+
+func updateHealth(target string, err error, presence bool) {
+	w, ok := getWorker(target)
+	if !ok {
+		return
+	}
+
+	w.statusMu.Lock()
+	wasQuarantined := w.status.Quarantined != ""
+	switch {
+	case err == nil && presence && !w.status.OfflineOnly:
+		// the failures are not reset by a check that does not flash the board
+	case err == nil:
+		w.status.InfraFailures = 0
+		w.status.OfflineOnly = false
+		w.status.Quarantined = ""
+	default:
+		if w.status.InfraFailures == 0 {
+			w.status.OfflineOnly = presence
+		} else if !presence {
+			w.status.OfflineOnly = false
+		}
+		w.status.InfraFailures++
+		if !wasQuarantined && w.status.InfraFailures >= quarantineAfter {
+			w.status.Quarantined = err.Error()
+			w.status.QuarantinedSince = time.Now()
+		}
+	}
+	failures := w.status.InfraFailures
+	quarantined := w.status.Quarantined != ""
+	w.statusMu.Unlock()
+
+	switch {
+	case quarantined && !wasQuarantined:
+		raiseAlert("board_quarantined", "board "+target+" has been quarantined after "+strconv.Itoa(failures)+" infrastructure failures in a row",
+			"target", target, "err", err)
+	case wasQuarantined && !quarantined:
+		slog.Info("board has returned to service", "target", target)
+	}
+}
+
+// quarantined returns why the board has been quarantined, if it has.
+func quarantined(target string) (string, bool) {
+	status := getStatus(target)
+	return status.Quarantined, status.Quarantined != ""
+}
+
+// recordAttempt records the health of the board based on how the last
+// attempt of a run went.
+func recordAttempt(target string, a *attempt) {
+	switch {
+	case a.kind == failureCancelled:
+		// says nothing about the board
+	case a.kind.retry():
+		err := a.err
+		if err == nil {
+			err = errors.New(string(a.kind) + " failure")
+		}
+		recordHealth(target, err)
+	default:
+		recordHealth(target, nil)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// testWorker registers a worker for the target with a clean status,
+// without starting it.
+func testWorker(t *testing.T, target string) *worker {
+	w := &worker{target: target}
+
+	workersMu.Lock()
+	prev, had := workers[target]
+	workers[target] = w
+	workersMu.Unlock()
+
+	t.Cleanup(func() {
+		workersMu.Lock()
+		defer workersMu.Unlock()
+
+		delete(workers, target)
+		if had {
+			workers[target] = prev
+		}
+	})
+	return w
+}
+
+// counterValue returns the value of the counter with these label values.
+func counterValue(c *counter, values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[formatLabels(c.labels, values)]
+}
+
+func TestUpdateHealth(t *testing.T) {
+	prev := quarantineAfter
+	quarantineAfter = 3
+	t.Cleanup(func() { quarantineAfter = prev })
+
+	steps := map[string]func(target string){
+		"infra": func(target string) {
+			recordAttempt(target, &attempt{kind: failureInfra, err: errors.New("no device found")})
+		},
+		"flash":      func(target string) { recordAttempt(target, &attempt{kind: failureFlash}) },
+		"cancelled":  func(target string) { recordAttempt(target, &attempt{kind: failureCancelled}) },
+		"assertion":  func(target string) { recordAttempt(target, &attempt{kind: failureAssertion}) },
+		"passed":     func(target string) { recordAttempt(target, &attempt{kind: failureNone}) },
+		"offline":    func(target string) { recordPresence(target, errors.New("device not found")) },
+		"found":      func(target string) { recordPresence(target, nil) },
+		"smoke-pass": func(target string) { recordHealth(target, nil) },
+		"smoke-fail": func(target string) { recordHealth(target, errors.New("smoke flash failed")) },
+	}
+
+	tests := []struct {
+		name        string
+		steps       []string
+		failures    int
+		quarantined bool
+		alerts      float64
+	}{
+		{
+			name:     "below threshold",
+			steps:    []string{"infra", "infra"},
+			failures: 2,
+		},
+		{
+			name:        "streak quarantines",
+			steps:       []string{"infra", "infra", "infra"},
+			failures:    3,
+			quarantined: true,
+			alerts:      1,
+		},
+		{
+			name:        "one alert for the streak",
+			steps:       []string{"infra", "flash", "infra", "flash", "infra"},
+			failures:    5,
+			quarantined: true,
+			alerts:      1,
+		},
+		{
+			name:     "pass resets",
+			steps:    []string{"infra", "infra", "passed", "infra"},
+			failures: 1,
+		},
+		{
+			name:     "failed tests reset, since the board works",
+			steps:    []string{"infra", "infra", "assertion", "infra"},
+			failures: 1,
+		},
+		{
+			name:        "cancelled is ignored",
+			steps:       []string{"infra", "infra", "cancelled", "infra"},
+			failures:    3,
+			quarantined: true,
+			alerts:      1,
+		},
+		{
+			name:        "presence does not reset flash failures",
+			steps:       []string{"flash", "flash", "found", "flash"},
+			failures:    3,
+			quarantined: true,
+			alerts:      1,
+		},
+		{
+			name:        "presence does not release a flash quarantine",
+			steps:       []string{"flash", "flash", "flash", "found"},
+			failures:    3,
+			quarantined: true,
+			alerts:      1,
+		},
+		{
+			name:     "smoke flash releases",
+			steps:    []string{"flash", "flash", "flash", "smoke-pass"},
+			failures: 0,
+			alerts:   1,
+		},
+		{
+			name:        "failed smoke flash keeps the quarantine",
+			steps:       []string{"flash", "flash", "flash", "smoke-fail"},
+			failures:    4,
+			quarantined: true,
+			alerts:      1,
+		},
+		{
+			name:     "offline released when found",
+			steps:    []string{"offline", "offline", "offline", "found"},
+			failures: 0,
+			alerts:   1,
+		},
+		{
+			name:        "offline and flash failures need a smoke flash",
+			steps:       []string{"offline", "flash", "offline", "found"},
+			failures:    3,
+			quarantined: true,
+			alerts:      1,
+		},
+		{
+			name:     "counts again after release",
+			steps:    []string{"offline", "offline", "offline", "found", "offline"},
+			failures: 1,
+			alerts:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := testWorker(t, "test-board")
+			alerts := counterValue(alertsRaised, "board_quarantined")
+
+			for _, step := range tt.steps {
+				steps[step](w.target)
+			}
+
+			status := getStatus(w.target)
+			if status.InfraFailures != tt.failures {
+				t.Errorf("failures = %d, want %d", status.InfraFailures, tt.failures)
+			}
+			if got, _ := quarantined(w.target); (got != "") != tt.quarantined {
+				t.Errorf("quarantined = %q, want quarantined %v", got, tt.quarantined)
+			}
+			if got := counterValue(alertsRaised, "board_quarantined") - alerts; got != tt.alerts {
+				t.Errorf("raised %v quarantine alerts, want %v", got, tt.alerts)
+			}
+		})
+	}
+}
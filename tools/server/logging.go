@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// setupLogging sets the default logger, which writes JSON records if
+// format is "json", and text records otherwise.
+func setupLogging(format string) {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// buildLogFile returns the name of the log file for the build, which is
+// next to the TinyGo binary downloaded for it.
+func buildLogFile(sha string) string {
+	return "tools/docker/versions/" + sha + ".log"
+}
+
+// runLogFile returns the name of the file with the complete flash and
+// test output for a board run, which is the check run with the ID run.
+func runLogFile(sha, target string, run int64) string {
+	return "tools/docker/versions/" + sha + "-" + target + "-" + strconv.FormatInt(run, 10) + ".log"
+}
+
+// log returns the logger for the build, which includes the sha in every record.
+func (build *Build) log() *slog.Logger {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	if build.logger == nil {
+		build.logger = slog.Default().With("sha", build.sha)
+	}
+	return build.logger
+}
+
+// openLog starts writing all of the log records for the build to its own
+// log file as well, including the full flash and test output.
+func (build *Build) openLog() {
+	f, err := os.OpenFile(buildLogFile(build.sha), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		build.log().Error("unable to open build log file", "err", err)
+		return
+	}
+
+	fh := slog.NewJSONHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug})
+	logger := slog.New(teeHandler{slog.Default().Handler(), fh}).With("sha", build.sha)
+
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	if build.logfile != nil {
+		build.logfile.Close()
+	}
+	build.logfile = f
+	build.logger = logger
+}
+
+// closeLog stops writing to the build's log file.
+func (build *Build) closeLog() {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	if build.logfile != nil {
+		build.logfile.Close()
+		build.logfile = nil
+	}
+	build.logger = nil
+}
+
+// teeHandler sends every record to all of its handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := make(teeHandler, len(t))
+	for i, h := range t {
+		result[i] = h.WithAttrs(attrs)
+	}
+	return result
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	result := make(teeHandler, len(t))
+	for i, h := range t {
+		result[i] = h.WithGroup(name)
+	}
+	return result
+}
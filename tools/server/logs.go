@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how many run logs to keep in memory
+const maxRunLogs = 100
+
+// runLog collects the output of the flash and tests for one board in a
+// build line by line, so that it can be watched while it is running.
+type runLog struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+	closed  bool
+
+	// file has the complete output, which is kept after the run.
+	file *os.File
+
+	// changed is closed and replaced every time the log changes.
+	changed chan struct{}
+}
+
+var (
+	// key is sha/target/run
+	runLogs      = make(map[string]*runLog)
+	runLogsOrder []string
+	runLogsMu    sync.Mutex
+)
+
+// newRunLog returns a new, empty log for the board run. Each check run has
+// its own log, so a re-run of the board keeps the log of the earlier run.
+func newRunLog(sha, target string, run int64) *runLog {
+	l := &runLog{changed: make(chan struct{})}
+	key := runLogKey(sha, target, run)
+
+	f, err := os.Create(runLogFile(sha, target, run))
+	if err != nil {
+		slog.Error("unable to create run log file", "sha", sha, "target", target, "run", run, "err", err)
+	} else {
+		l.file = f
+	}
+
+	runLogsMu.Lock()
+	defer runLogsMu.Unlock()
+
+	if _, ok := runLogs[key]; !ok {
+		runLogsOrder = append(runLogsOrder, key)
+	}
+	runLogs[key] = l
+
+	// forget the oldest logs
+	for len(runLogsOrder) > maxRunLogs {
+		delete(runLogs, runLogsOrder[0])
+		runLogsOrder = runLogsOrder[1:]
+	}
+
+	return l
+}
+
+// getRunLog returns the log for the board run.
+func getRunLog(sha, target string, run int64) (*runLog, bool) {
+	runLogsMu.Lock()
+	defer runLogsMu.Unlock()
+
+	l, ok := runLogs[runLogKey(sha, target, run)]
+	return l, ok
+}
+
+func runLogKey(sha, target string, run int64) string {
+	return sha + "/" + target + "/" + strconv.FormatInt(run, 10)
+}
+
+// Write adds the output to the log. Complete lines can be read right away.
+func (l *runLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		l.file.Write(p)
+	}
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.lines = append(l.lines, string(bytes.TrimRight(l.partial[:i], "\r")))
+		l.partial = l.partial[i+1:]
+	}
+	l.notify()
+
+	return len(p), nil
+}
+
+// Printf adds a line to the log.
+func (l *runLog) Printf(format string, a ...any) {
+	fmt.Fprintf(l, format+"\n", a...)
+}
+
+// Close marks the log as complete.
+func (l *runLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.partial) > 0 {
+		l.lines = append(l.lines, string(l.partial))
+		l.partial = nil
+	}
+	l.closed = true
+	l.notify()
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// notify wakes up anyone following the log. l.mu must be held.
+func (l *runLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// next returns the lines starting at from, if the log has been closed,
+// and a channel that is closed when there is more to read.
+func (l *runLog) next(from int) ([]string, bool, chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var lines []string
+	if from < len(l.lines) {
+		lines = append(lines, l.lines[from:]...)
+	}
+	return lines, l.closed, l.changed
+}
+
+// streamCommand runs the command, writing its output to w line by line while
+// it runs, and then returns all of the output. The command runs in its own
+// process group, so that if its context is done the command and everything
+// it started are killed.
+func streamCommand(cmd *exec.Cmd, w io.Writer) (string, error) {
+	var out bytes.Buffer
+	mw := io.MultiWriter(&out, w)
+	cmd.Stdout = mw
+	cmd.Stderr = mw
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 10 * time.Second
+
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// handleRunLogStream streams the log for a board run as Server-Sent Events.
+// Each line of output is a "message" event, and an "end" event is sent once
+// the run has finished.
+func handleRunLogStream(w http.ResponseWriter, r *http.Request) {
+	sha, target, run, ok := runLogPath(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	l, ok := getRunLog(sha, target, run)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	sent := 0
+	for {
+		lines, closed, changed := l.next(sent)
+		for _, line := range lines {
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		}
+		sent += len(lines)
+
+		if closed {
+			fmt.Fprint(w, "event: end\ndata: \n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleLatestRunLog redirects to the log of the latest run of the board
+// for the sha, which is the one shown on the dashboard.
+func handleLatestRunLog(w http.ResponseWriter, r *http.Request) {
+	sha, target := r.PathValue("sha"), r.PathValue("target")
+	build, ok := builds.get(sha)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	run, ok := build.run(target)
+	if !ok {
+		result, done := build.runResults()[target]
+		if !done {
+			http.NotFound(w, r)
+			return
+		}
+		run = result.ID
+	}
+	http.Redirect(w, r, "/logs/"+runLogKey(sha, target, run), http.StatusFound)
+}
+
+// handleRunLog shows a page that follows the log for a board run.
+func handleRunLog(w http.ResponseWriter, r *http.Request) {
+	sha, target, run, ok := runLogPath(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := getRunLog(sha, target, run); !ok {
+		// the log is no longer in memory, but may still be on disk
+		http.Redirect(w, r, "/logs/"+runLogKey(sha, target, run)+"/full", http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := runLogTemplate.Execute(w, struct {
+		SHA, Target string
+		Run         int64
+	}{sha, target, run})
+	if err != nil {
+		slog.Error("unable to show run log", "err", err)
+	}
+}
+
+// handleFullRunLog returns the complete log for a board run as text.
+func handleFullRunLog(w http.ResponseWriter, r *http.Request) {
+	sha, target, run, ok := runLogPath(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeFile(w, r, runLogFile(sha, target, run))
+}
+
+// runLogPath returns the sha, target and check run ID in the path of a
+// request for a run log, and whether they are all valid.
+func runLogPath(r *http.Request) (string, string, int64, bool) {
+	sha, target := r.PathValue("sha"), r.PathValue("target")
+	run, err := strconv.ParseInt(r.PathValue("run"), 10, 64)
+	if err != nil || !validSHA.MatchString(sha) || !validTarget.MatchString(target) {
+		return "", "", 0, false
+	}
+	return sha, target, run, true
+}
+
+var (
+	validSHA    = regexp.MustCompile(`^[0-9a-f]+$`)
+	validTarget = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+)
+
+// runLogURL returns the URL of the page for the log of a board run,
+// or an empty string if the server's public URL is not known. The URL
+// without a check run ID is for the latest run of the board.
+func runLogURL(sha, target string, run int64) string {
+	if publicURL == "" {
+		return ""
+	}
+	if run == 0 {
+		return publicURL + "/logs/" + sha + "/" + target
+	}
+	return publicURL + "/logs/" + runLogKey(sha, target, run)
+}
+
+var runLogTemplate = template.Must(template.New("runlog").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>TinyHCI {{.Target}} {{.SHA}} run {{.Run}}</title>
+</head>
+<body>
+<h1>{{.Target}} for {{.SHA}}</h1>
+<pre id="log"></pre>
+<p id="status">running...</p>
+<script>
+const out = document.getElementById("log");
+const es = new EventSource("/logs/{{.SHA}}/{{.Target}}/{{.Run}}/stream");
+es.onmessage = (e) => {
+	out.textContent += e.data + "\n";
+	window.scrollTo(0, document.body.scrollHeight);
+};
+es.addEventListener("end", () => {
+	document.getElementById("status").textContent = "finished";
+	es.close();
+});
+</script>
+</body>
+</html>
+`))
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"net/http"
@@ -18,6 +23,8 @@ import (
 const (
 	useCurrentBinaryRelease = false // set to true to use the already installed tinygo
 	officialRelease         = "https://github.com/tinygo-org/tinygo/releases/download/v0.21.0/tinygo0.21.0.linux-amd64.tar.gz"
+
+	dockerBuildTimeout = 30 * time.Minute
 )
 
 var (
@@ -27,14 +34,28 @@ var (
 
 	ghwebhookpath = "/webhooks"
 	ciwebhookpath = "/buildhook"
-
-	client *github.Client
-
-	// key is sha
-	builds map[string]*Build
+	storefile     = "tinyhci.json"
+	resultsfile   = "tinyhci-results.jsonl"
+	webhooksdir   = "webhooks"
+	boardsfile    = "tools/server/boards.json"
+
+	// publicURL is the URL that the server can be reached at from outside,
+	// such as "https://tinyhci.example.com", used to link to the logs.
+	publicURL string
+
+	client     *github.Client
+	store      *buildStore
+	deliveries *deliveryArchive
 )
 
 func main() {
+	setupLogging(os.Getenv("LOGFORMAT"))
+
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		runCommand(os.Args[2:])
+		return
+	}
+
 	ghwebhookpath = os.Getenv("GHWEBHOOKPATH")
 	if ghwebhookpath == "" {
 		log.Fatal("You must set an ENV var with your GHWEBHOOKPATH")
@@ -82,224 +103,529 @@ func main() {
 
 	client, err = authenticateGithubClient(int64(appid), int64(installid), ghkeyfile)
 	if err != nil {
-		log.Println(err)
+		slog.Error("unable to authenticate with GitHub", "err", err)
+	}
+
+	reporter, err = newReporter(os.Getenv("REPORTER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if sf := os.Getenv("STOREFILE"); sf != "" {
+		storefile = sf
+	}
+
+	if bf := os.Getenv("BOARDSFILE"); bf != "" {
+		boardsfile = bf
+	}
+
+	bs, err := loadBoards(boardsfile)
+	if err != nil {
+		log.Fatal("Unable to load boards: ", err)
+	}
+	setBoards(bs)
+
+	// reload the boards when the config file changes
+	go watchBoards(boardsfile)
+
+	store, err = openStore(storefile)
+	if err != nil {
+		log.Fatal("Unable to open build store: ", err)
+	}
+
+	if rf := os.Getenv("RESULTSFILE"); rf != "" {
+		resultsfile = rf
+	}
+
+	testResults, err = openResultStore(resultsfile)
+	if err != nil {
+		log.Fatal("Unable to open test results: ", err)
+	}
+
+	if wd := os.Getenv("WEBHOOKSDIR"); wd != "" {
+		webhooksdir = wd
+	}
+
+	deliveries, err = openDeliveryArchive(webhooksdir)
+	if err != nil {
+		log.Fatal("Unable to open webhook archive: ", err)
+	}
+
+	supersedeImmediately = os.Getenv("SUPERSEDE") == "immediate"
+	alertURL = os.Getenv("ALERTURL")
+	publicURL = strings.TrimSuffix(os.Getenv("PUBLICURL"), "/")
+
+	if bb := os.Getenv("BASEBRANCH"); bb != "" {
+		baseBranch = bb
+	}
+	switch fo := os.Getenv("FAILON"); fo {
+	case "", "any":
+	case "regressions":
+		failOnRegressionsOnly = true
+	default:
+		log.Fatal("Invalid FAILON: ", fo)
+	}
+
+	if hi := os.Getenv("HEALTHINTERVAL"); hi != "" {
+		healthInterval, err = time.ParseDuration(hi)
+		if err != nil || healthInterval <= 0 {
+			log.Fatal("Invalid HEALTHINTERVAL: ", hi)
+		}
+	}
+	if qa := os.Getenv("QUARANTINEAFTER"); qa != "" {
+		quarantineAfter, err = strconv.Atoi(qa)
+		if err != nil || quarantineAfter <= 0 {
+			log.Fatal("Invalid QUARANTINEAFTER: ", qa)
+		}
+	}
+
+	grace := defaultShutdownGrace
+	if sg := os.Getenv("SHUTDOWNGRACE"); sg != "" {
+		grace, err = time.ParseDuration(sg)
+		if err != nil {
+			log.Fatal("Invalid SHUTDOWNGRACE: ", err)
+		}
 	}
 
-	builds = make(map[string]*Build)
 	buildsCh := make(chan *Build)
 
 	// start go routine to actually do the building
 	go processBuilds(buildsCh)
+	go monitorHealth()
 
-	// fetch any builds that are already in progress
-	go handlePreviouslyQueuedBuilds(buildsCh)
+	// resume any builds that were queued or running when the server stopped
+	go resumeBuilds(buildsCh)
 
 	// start the webhook server
 	http.HandleFunc(ghwebhookpath, func(w http.ResponseWriter, r *http.Request) {
-		payload, err := github.ValidatePayload(r, []byte(ghkey))
-		if err != nil {
-			log.Println("Invalid webhook payload")
+		if shuttingDown() {
+			http.Error(w, "TinyHCI is shutting down", http.StatusServiceUnavailable)
 			return
 		}
-		event, err := github.ParseWebHook(github.WebHookType(r), payload)
+
+		payload, err := github.ValidatePayload(r, []byte(ghkey))
 		if err != nil {
-			log.Println("Invalid webhook event")
+			slog.Warn("invalid webhook payload", "err", err)
 			return
 		}
-		switch event := event.(type) {
-		case *github.PushEvent:
-			// ignore pushes because we only care about checks API
-			return
-		case *github.WorkflowRunEvent:
-			log.Printf("Github workflowrun on '%s' event %s %s for %d %s\n",
-				event.WorkflowRun.GetName(),
-				event.WorkflowRun.GetStatus(),
-				event.WorkflowRun.GetConclusion(),
-				event.WorkflowRun.GetID(),
-				event.WorkflowRun.GetHeadSHA())
-
-			if event.WorkflowRun.GetStatus() == "completed" &&
-				event.WorkflowRun.GetConclusion() == "success" &&
-				event.WorkflowRun.GetName() == "Linux" {
-				url, err := getTinygoBinaryURLFromGH(event.WorkflowRun.GetID())
-				if err != nil {
-					log.Println(err)
+
+		// GitHub sometimes delivers the same webhook more than once
+		delivery := r.Header.Get("X-GitHub-Delivery")
+		eventType := github.WebHookType(r)
+		if err := deliveries.record(delivery, eventType, payload); err != nil {
+			if errors.Is(err, errDuplicateDelivery) {
+				slog.Info("ignoring duplicate webhook delivery", "delivery", delivery, "type", eventType)
+				return
+			}
+			slog.Error("unable to archive webhook delivery", "delivery", delivery, "err", err)
+		}
+
+		handleEvent(eventType, payload, buildsCh)
+	})
+
+	// replay archived webhook deliveries
+	if admintoken := os.Getenv("ADMINTOKEN"); admintoken != "" {
+		http.HandleFunc("POST /admin/replay/{delivery}", requireToken(admintoken, func(w http.ResponseWriter, r *http.Request) {
+			handleReplay(w, r, buildsCh)
+		}))
+	}
+
+	// show what the server is doing
+	http.HandleFunc("/{$}", handleDashboard)
+	http.HandleFunc("GET /metrics", handleMetrics)
+	http.HandleFunc("GET /tests/stats", handleTestStats)
+	http.HandleFunc("GET /tests/flaky", handleFlakyTests)
+	http.HandleFunc("GET /logs/{sha}/{target}", handleLatestRunLog)
+	http.HandleFunc("GET /logs/{sha}/{target}/{run}", handleRunLog)
+	http.HandleFunc("GET /logs/{sha}/{target}/{run}/stream", handleRunLogStream)
+	http.HandleFunc("GET /logs/{sha}/{target}/{run}/full", handleFullRunLog)
+
+	slog.Info("starting TinyHCI server", "org", ghorg, "repo", ghrepo)
+	server := &http.Server{Addr: ":8000"}
+	go waitForShutdown(server, grace)
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	slog.Info("TinyHCI server stopped")
+}
+
+// handleEvent handles a single webhook event from GitHub.
+func handleEvent(eventType string, payload []byte, buildsCh chan *Build) {
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		slog.Warn("invalid webhook event", "err", err)
+		return
+	}
+	switch event := event.(type) {
+	case *github.PushEvent:
+		// ignore pushes because we only care about checks API
+		return
+	case *github.WorkflowRunEvent:
+		slog.Info("github workflow run event",
+			"name", event.WorkflowRun.GetName(),
+			"status", event.WorkflowRun.GetStatus(),
+			"conclusion", event.WorkflowRun.GetConclusion(),
+			"id", event.WorkflowRun.GetID(),
+			"sha", event.WorkflowRun.GetHeadSHA())
+
+		if event.WorkflowRun.GetStatus() == "completed" &&
+			event.WorkflowRun.GetConclusion() == "success" &&
+			event.WorkflowRun.GetName() == "Linux" {
+			b, ok := builds.get(event.WorkflowRun.GetHeadSHA())
+			switch {
+			case !ok:
+				b = NewBuild(event.WorkflowRun.GetHeadSHA())
+				if !builds.addNew(b) {
+					b.log().Info("build was added by another event, not queueing it again")
 					return
 				}
-
-				b, ok := builds[event.WorkflowRun.GetHeadSHA()]
-				if !ok {
-					b = NewBuild(event.WorkflowRun.GetHeadSHA())
-					builds[event.WorkflowRun.GetHeadSHA()] = b
+				buildsReceived.inc()
+			default:
+				if _, cancelled := b.cancelled(); cancelled {
+					b.log().Info("build has been cancelled, not queueing it")
+					return
+				}
+				// only a build that is waiting for CI is queued, not one
+				// that is already running or has finished
+				if err := b.transition(BuildQueued); err != nil {
+					b.log().Warn("not queueing build", "err", err)
+					return
 				}
-				b.binaryURL = url
-				b.pendingCI = false
-				buildsCh <- b
 			}
+			queueAfterCI(b, event.WorkflowRun, buildsCh)
+		}
 
-		case *github.WorkflowJobEvent:
-			log.Printf("Github workflowjob on '%s' event %s %s for %d %s\n",
-				event.WorkflowJob.GetName(),
-				event.WorkflowJob.GetStatus(),
-				event.WorkflowJob.GetConclusion(),
-				event.WorkflowJob.GetID(),
-				event.WorkflowJob.GetHeadSHA())
-
-		case *github.CheckSuiteEvent:
-			log.Printf("Github checksuite event %s %s for %d %s\n",
-				event.CheckSuite.GetStatus(),
-				event.CheckSuite.GetConclusion(),
-				event.CheckSuite.GetID(),
-				event.CheckSuite.GetHeadSHA())
-
-			switch event.CheckSuite.GetStatus() {
-			case "completed":
-				// just in case we want to do something here
-			case "queued":
-				// received when a new commit is pushed
-				build := NewBuild(event.CheckSuite.GetHeadSHA())
-				build.pendingCI = true
-				build.started = time.Now()
-				builds[build.sha] = build
-				build.pendingCheckSuite()
-			default:
+	case *github.WorkflowJobEvent:
+		slog.Info("github workflow job event",
+			"name", event.WorkflowJob.GetName(),
+			"status", event.WorkflowJob.GetStatus(),
+			"conclusion", event.WorkflowJob.GetConclusion(),
+			"id", event.WorkflowJob.GetID(),
+			"sha", event.WorkflowJob.GetHeadSHA())
+
+	case *github.CheckSuiteEvent:
+		slog.Info("github check suite event",
+			"action", event.GetAction(),
+			"status", event.CheckSuite.GetStatus(),
+			"conclusion", event.CheckSuite.GetConclusion(),
+			"id", event.CheckSuite.GetID(),
+			"sha", event.CheckSuite.GetHeadSHA())
+
+		sha := event.CheckSuite.GetHeadSHA()
+		switch event.GetAction() {
+		case "requested":
+			// received when a new commit is pushed
+			build := NewBuild(sha)
+			build.state = BuildAwaitingCI
+			build.head = checkSuiteHead(event.CheckSuite, event.GetRepo())
+			if !builds.addNew(build) {
+				build.log().Info("already have a build for this commit, not creating check runs again")
+				return
 			}
+			awaitCI(build)
+
+			// cancel any older builds for the same branch that are still
+			// waiting for CI
+			supersede(build)
+
+		case "rerequested":
+			// received for "Re-run all checks" on the check suite
+			err := rerun(sha, enabledTargets(), runOptions{}, buildsCh)
+			switch {
+			case errors.Is(err, errBuildNotFinished):
+				slog.Info("build has not finished yet, not re-running it", "sha", sha)
+			case err != nil:
+				if pending, perr := workflowInProgress(sha); perr != nil || !pending {
+					slog.Error("unable to re-run build", "sha", sha, "err", err)
+					failRerun(sha, enabledTargets(), err)
+					return
+				}
 
-		case *github.CheckRunEvent:
-			log.Printf("Github checkrun event %s %s for %d %s %s %s %s %s\n",
-				event.CheckRun.GetStatus(),
-				event.CheckRun.GetConclusion(),
-				event.CheckRun.GetID(),
-				event.CheckRun.GetName(),
-				event.GetAction(),
-				event.CheckRun.GetHeadSHA(),
-				event.CheckRun.GetExternalID(),
-				event.CheckRun.GetDetailsURL())
-
-			switch event.CheckRun.GetStatus() {
-			case "completed":
-				if event.GetAction() == "rerequested" {
-					wr, err := getRecentWorkflowRunForSHA("success", event.CheckRun.GetHeadSHA())
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					performCheckRun(event.CheckRun, wr.GetID(), buildsCh)
+				// CI has not built the TinyGo binary for the commit yet,
+				// so the build waits for the workflow to finish
+				slog.Warn("unable to re-run build, waiting for CI", "sha", sha, "err", err)
+				build := NewBuild(sha)
+				build.state = BuildAwaitingCI
+				if !builds.replaceFinished(build) {
+					return
 				}
+				awaitCI(build)
+			}
+		}
+
+	case *github.CheckRunEvent:
+		slog.Info("github check run event",
+			"action", event.GetAction(),
+			"status", event.CheckRun.GetStatus(),
+			"conclusion", event.CheckRun.GetConclusion(),
+			"id", event.CheckRun.GetID(),
+			"name", event.CheckRun.GetName(),
+			"sha", event.CheckRun.GetHeadSHA(),
+			"external_id", event.CheckRun.GetExternalID(),
+			"details_url", event.CheckRun.GetDetailsURL())
+
+		if event.CheckRun.GetStatus() != "completed" {
+			return
+		}
+
+		sha := event.CheckRun.GetHeadSHA()
+		target, err := parseTarget(event.CheckRun.GetName())
+		if err != nil {
+			// not one of ours
+			return
+		}
 
-			case "queued":
-				// received when a new commit is pushed
+		var targets []string
+		var opts runOptions
+		switch event.GetAction() {
+		case "rerequested":
+			targets = []string{target}
+		case "requested_action":
+			switch id := event.GetRequestedAction().Identifier; id {
+			case actionRerun:
+				targets = []string{target}
+			case actionRerunVerbose:
+				targets, opts.verbose = []string{target}, true
+			case actionRerunSuite:
+				targets = enabledTargets()
 			default:
+				slog.Warn("unknown check run action", "sha", sha, "action", id)
+				return
 			}
-
 		default:
-			log.Println("Unexpected Github event:", event)
+			return
+		}
+
+		err = rerun(sha, targets, opts, buildsCh)
+		switch {
+		case errors.Is(err, errBuildNotFinished):
+			slog.Info("build has not finished yet, not re-running it", "sha", sha, "targets", targets)
+			rerunNotStarted(event.CheckRun, target)
+		case err != nil:
+			slog.Error("unable to re-run check run", "sha", sha, "targets", targets, "err", err)
 		}
-	})
 
-	log.Printf("Starting TinyHCI server for %s/%s\n", ghorg, ghrepo)
-	http.ListenAndServe(":8000", nil)
+	case *github.IssueCommentEvent:
+		slog.Info("github issue comment event",
+			"action", event.GetAction(),
+			"issue", event.GetIssue().GetNumber(),
+			"user", event.GetComment().GetUser().GetLogin(),
+			"id", event.GetComment().GetID())
+
+		handleComment(event, buildsCh)
+
+	default:
+		slog.Warn("unexpected github event", "type", eventType)
+	}
 }
 
 // processBuilds is run as a go routine to pull new builds
 // from the build channel, and then perform the needed build
-// tasks aka build docker image, then flash/test for each board.
-func processBuilds(builds chan *Build) {
+// tasks aka build docker image, then flash/test all of the
+// boards in parallel using the board workers.
+func processBuilds(buildsCh chan *Build) {
+	defer close(stopped)
+
 	for {
 		select {
-		case build := <-builds:
-			log.Printf("Starting tests for commit %s\n", build.sha)
-			build.startCheckSuite()
+		case <-stopping:
+			return
+		case build := <-buildsCh:
+			build.process()
+		}
+	}
+}
 
-			url := officialRelease
-			if !useCurrentBinaryRelease {
-				url = build.binaryURL
-			}
+// process runs the tests for a build that has been taken off the queue.
+// The build's log file is closed when it returns, whether the build
+// finished, was requeued or could not go on.
+func (build *Build) process() {
+	if reason, ok := build.cancelled(); ok {
+		build.log().Info("skipping cancelled build", "reason", reason)
+		return
+	}
 
-			log.Printf("Downloading TinyGo from %s\n", url)
-			err := downloadBinary(url, build.sha)
-			if err != nil {
-				log.Println(err)
-				build.failCheckSuite("binary download failed")
-				continue
-			}
+	// the build is still queued, and resumes when the server restarts
+	if shuttingDown() {
+		return
+	}
 
-			log.Printf("Building docker image using TinyGo from %s\n", url)
-			err = buildDocker(build.sha)
-			if err != nil {
-				log.Println(err)
-				build.failCheckSuite("docker build failed")
-				continue
-			}
+	build.openLog()
+	defer build.closeLog()
+	if err := build.transition(BuildDownloading); err != nil {
+		build.log().Error("unable to start build", "err", err)
+		return
+	}
+	build.log().Info("starting tests")
+	phaseDuration.since(build.getQueued(), "queue", "")
+	build.save()
+	build.startCheckSuite()
+
+	start := time.Now()
+	err := build.download()
+	phaseDuration.since(start, "download", "")
+	if err != nil {
+		build.log().Error("binary download failed", "phase", "download", "err", err)
+		build.failCheckSuite("binary download failed")
+		build.finish()
+		return
+	}
 
-			log.Printf("Running checks for commit %s\n", build.sha)
-			for _, run := range build.runs {
-				target, err := parseTarget(run.GetName())
-				if err != nil {
-					log.Println(err)
-					build.failCheckRun(target, err.Error())
-					continue
-				}
-				board := GetBoard(target)
-				if board != nil {
-					build.processBoardRun(board)
-				}
-			}
-		}
+	if shuttingDown() {
+		build.requeueCheckSuite(shutdownReason)
+		build.requeue()
+		return
+	}
+
+	if err := build.transition(BuildBuildingImage); err != nil {
+		build.log().Info("build cancelled before docker build", "err", err)
+		return
+	}
+	build.save()
+	if !build.buildImage() {
+		return
+	}
+
+	if err := build.transition(BuildRunning); err != nil {
+		build.log().Info("build cancelled before running checks", "err", err)
+		return
 	}
+	build.save()
+	build.log().Info("running checks")
+	build.runBoards()
+
+	if shuttingDown() && len(build.targets()) > 0 {
+		build.requeue()
+		return
+	}
+	build.finish()
+}
+
+// buildImage builds the docker image for the build, unless it already
+// exists. It returns false if the build cannot go on, in which case its
+// check runs have been completed or requeued.
+func (build *Build) buildImage() bool {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(build.ctx, dockerBuildTimeout)
+	defer cancel()
+
+	var err error
+	if imageExists(ctx, build.sha) {
+		build.log().Info("reusing docker image", "phase", "docker_build")
+	} else {
+		build.log().Info("building docker image", "phase", "docker_build")
+		err = buildDocker(ctx, build.sha)
+	}
+	phaseDuration.since(start, "docker_build", "")
+
+	reason, cancelled := build.cancelled()
+	switch {
+	case timedOut(ctx):
+		build.log().Error("docker build timed out", "phase", "docker_build", "err", err)
+		build.timeoutCheckSuite("docker build", dockerBuildTimeout)
+	case build.interrupted():
+		build.log().Warn("docker build interrupted by shutdown", "phase", "docker_build")
+		build.requeueCheckSuite(shutdownReason)
+		build.requeue()
+		return false
+	case cancelled && build.ctx.Err() != nil:
+		build.log().Info("docker build stopped, build cancelled", "phase", "docker_build", "reason", reason)
+		build.cancelCheckSuite(reason)
+		build.finish()
+		return false
+	case err != nil:
+		build.log().Error("docker build failed", "phase", "docker_build", "err", err)
+		build.failCheckSuite("docker build failed")
+	}
+	if err != nil {
+		build.finish()
+		return false
+	}
+	return true
 }
 
 // buildDocker does the docker build for the binary download
 // with this SHA.
-func buildDocker(sha string) error {
+func buildDocker(ctx context.Context, sha string) error {
 	buildarg := fmt.Sprintf("TINYGO_DOWNLOAD_SHA=%s", sha)
 	buildtag := "tinygohci:" + sha[:7]
-	cmd := exec.Command("docker", "build",
+	cmd := exec.CommandContext(ctx, "docker", "build",
 		"-t", buildtag,
 		"-f", "tools/docker/Dockerfile",
 		"--build-arg", buildarg, ".")
 	cmd.Env = os.Environ()
 	cmd.Env = append(cmd.Env, "DOCKER_BUILDKIT=1")
 
-	out, err := cmd.CombinedOutput()
+	out, err := streamCommand(cmd, io.Discard)
 	if err != nil {
-		log.Println(err)
-		log.Println(string(out))
+		slog.Error("docker build failed", "sha", sha, "err", err, "output", out)
 		return err
 	}
 
 	return nil
 }
 
+// download downloads the TinyGo binary for the build, unless it is
+// already in the cache. The download URL for a CI artifact expires soon
+// after it is looked up, so it is looked up right before the download.
+func (build *Build) download() error {
+	if fileExists(tarballFile(build.sha)) {
+		build.log().Info("using cached TinyGo", "phase", "download")
+		return nil
+	}
+
+	url := officialRelease
+	if !useCurrentBinaryRelease {
+		var err error
+		url, err = getTinygoBinaryURLFromGH(build.getWorkflowRun())
+		if err != nil {
+			return err
+		}
+	}
+
+	build.log().Info("downloading TinyGo", "phase", "download", "url", url)
+	return downloadBinary(url, build.sha)
+}
+
 // downloadBinary does the download for the binary build
 // with this SHA.
 func downloadBinary(url, sha string) error {
 	// check if the file is already downloaded for this sha
-	if !fileExists("tools/docker/versions/" + sha + ".tar.gz") {
-		log.Println("Downloading binary for", sha)
+	if !fileExists(tarballFile(sha)) {
+		slog.Info("downloading binary", "sha", sha)
+
+		// release tarballs can be used as is, CI artifacts are zipped
+		if strings.HasSuffix(url, ".tar.gz") {
+			resp, err := grab.Get(tarballFile(sha), url)
+			if err != nil {
+				return err
+			}
+			slog.Info("downloaded binary", "sha", sha, "bytes", resp.BytesComplete())
+			return nil
+		}
 
 		resp, err := grab.Get("tinygo-latest.zip", url)
 		if err != nil {
 			return err
 		}
-		log.Println("downloaded bytes:", resp.BytesComplete())
+		slog.Info("downloaded binary", "sha", sha, "bytes", resp.BytesComplete())
 		// unzip
-		log.Println("unzipping")
+		slog.Info("unzipping binary", "sha", sha)
 		out, err := exec.Command("unzip", "tinygo-latest.zip",
 			"tinygo*.linux-amd64.tar.gz").CombinedOutput()
 		if err != nil {
 			return err
 		}
-		log.Println(string(out))
+		slog.Debug("unzipped binary", "sha", sha, "output", string(out))
 
 		// move file
-		log.Println("moving file")
+		slog.Info("moving binary", "sha", sha)
 		f, err := filepath.Glob("tinygo*.linux-amd64.tar.gz")
 		if err != nil {
 			return err
 		}
-		err = os.Rename(f[0], "tools/docker/versions/"+sha+".tar.gz")
+		err = os.Rename(f[0], tarballFile(sha))
 		if err != nil {
 			return err
 		}
@@ -322,62 +648,58 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-func performCheckRun(cr *github.CheckRun, runID int64, buildsCh chan *Build) {
-	// do the retest here
-	url, err := getTinygoBinaryURLFromGH(runID)
-	if err != nil {
-		log.Println(err)
-		return
+// resumeBuilds restores the builds from the store, and queues any
+// that were waiting to be tested or were running when the server stopped,
+// or whose CI finished while it was stopped.
+func resumeBuilds(buildsCh chan *Build) {
+	// all of the builds are added first, so that a build for an older
+	// commit can be superseded by a newer one
+	stored := store.load()
+	for _, build := range stored {
+		builds.add(build)
 	}
 
-	target, err := parseTarget(cr.GetName())
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	for _, build := range stored {
+		state := build.getState()
+		if state == BuildAwaitingCI {
+			resumeAwaitingCI(build, buildsCh)
+			continue
+		}
+		if state.active() {
+			// the build was interrupted, so it starts again from the download
+			if err := build.requeueState(); err != nil {
+				build.log().Error("unable to resume build", "err", err)
+				continue
+			}
+		}
+		if state != BuildQueued && !state.active() {
+			continue
+		}
 
-	build := NewBuild(cr.GetHeadSHA())
-	build.binaryURL = url
-	build.runs[target] = cr
-	builds[build.sha] = build
+		if len(build.targets()) == 0 {
+			build.finish()
+			continue
+		}
 
-	// handoff to channel for processing
-	buildsCh <- build
+		build.log().Info("resuming tests")
+		buildsCh <- build
+	}
 }
 
-// handlePreviouslyQueuedBuilds retrieves builds that were
-// already queued before the server was started, probably due
-// to some error or failure.
-func handlePreviouslyQueuedBuilds(buildsCh chan *Build) {
-	cibuilds, err := getRecentSuccessfulWorkflowRuns()
+// resumeAwaitingCI queues a build that was waiting for CI when the server
+// stopped, if the CI finished while it was stopped. Otherwise the build
+// keeps waiting for the workflow_run webhook.
+func resumeAwaitingCI(build *Build, buildsCh chan *Build) {
+	wr, err := getRecentWorkflowRunForSHA("success", build.sha)
 	if err != nil {
-		log.Println(err)
+		build.log().Info("still waiting for CI", "err", err)
 		return
 	}
-
-	for _, cib := range cibuilds {
-		// any in_progress checkruns for this build? restart them
-		runs, err := findCheckRuns(cib.GetHeadSHA(), "in_progress")
-		if err != nil {
-			log.Println(err)
-			return
-		}
-
-		for _, run := range runs {
-			performCheckRun(run, cib.GetID(), buildsCh)
-		}
+	if err := build.transition(BuildQueued); err != nil {
+		build.log().Error("unable to resume build", "err", err)
+		return
 	}
 
-	for _, cib := range cibuilds {
-		// any queued checkruns for this build?
-		runs, err := findCheckRuns(cib.GetHeadSHA(), "queued")
-		if err != nil {
-			log.Println(err)
-			return
-		}
-
-		for _, run := range runs {
-			performCheckRun(run, cib.GetID(), buildsCh)
-		}
-	}
+	build.log().Info("CI finished while the server was stopped, queueing build")
+	queueAfterCI(build, wr, buildsCh)
 }
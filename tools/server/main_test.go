@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBuildImageCancelled(t *testing.T) {
+	prev := reporter
+	reporter = &consoleReporter{}
+	t.Cleanup(func() { reporter = prev })
+
+	build := NewBuild("0123456789abcdef")
+	build.state = BuildBuildingImage
+	build.setRun("pico", 1)
+	build.setRun("microbit", 2)
+
+	// a /tinyhci cancel stops the docker build right away
+	build.cancel("Cancelled by @someone.", true)
+
+	if build.buildImage() {
+		t.Fatal("buildImage() = true, want false for a cancelled build")
+	}
+	if got := build.getState(); got != BuildCancelled {
+		t.Errorf("state = %s, want %s", got, BuildCancelled)
+	}
+	for target, r := range build.runResults() {
+		if r.Conclusion != "cancelled" {
+			t.Errorf("%s conclusion = %q, want %q", target, r.Conclusion, "cancelled")
+		}
+	}
+	if targets := build.targets(); len(targets) != 0 {
+		t.Errorf("build still has check runs for %v", targets)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exported in the Prometheus text format at /metrics.
+var (
+	buildsReceived = newCounter("tinyhci_builds_received_total",
+		"Builds received from GitHub.")
+	buildsCompleted = newCounter("tinyhci_builds_completed_total",
+		"Builds completed, by conclusion.", "conclusion")
+	boardFailures = newCounter("tinyhci_board_failures_total",
+		"Board runs that failed, by board and phase.", "target", "phase")
+	alertsRaised = newCounter("tinyhci_alerts_total",
+		"Alerts raised for the maintainers, by kind.", "kind")
+	phaseDuration = newHistogram("tinyhci_phase_duration_seconds",
+		"Time taken by each phase of a build, including the time spent queued. Board phases include the target.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}, "phase", "target")
+)
+
+// counter is a Prometheus counter with labels.
+type counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+// inc adds one to the counter with these label values.
+func (c *counter) inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[formatLabels(c.labels, values)]++
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, labels := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, labels, c.values[labels])
+	}
+}
+
+// histogram is a Prometheus histogram with labels.
+type histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+}
+
+// observe records the duration in the histogram with these label values.
+func (h *histogram) observe(d time.Duration, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := formatLabels(h.labels, values)
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{labels: values, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+
+	s := d.Seconds()
+	for i, le := range h.buckets {
+		if s <= le {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += s
+}
+
+// since records the time since start in the histogram.
+func (h *histogram) since(start time.Time, values ...string) {
+	h.observe(time.Since(start), values...)
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		for i, le := range h.buckets {
+			labels := formatLabels(slices.Concat(h.labels, []string{"le"}), slices.Concat(v.labels, []string{fmt.Sprint(le)}))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, v.counts[i])
+		}
+		labels := formatLabels(slices.Concat(h.labels, []string{"le"}), slices.Concat(v.labels, []string{"+Inf"}))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, v.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, key, v.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, v.count)
+	}
+}
+
+// writeGauge writes a gauge with a single value for each set of labels.
+func writeGauge(w io.Writer, name, help string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %g\n", name, key, values[key])
+	}
+}
+
+// formatLabels formats the labels, such as {target="pico",phase="flash"}.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		pairs[i] = name + `="` + v + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handleMetrics writes all of the metrics in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	buildsReceived.write(w)
+	buildsCompleted.write(w)
+	boardFailures.write(w)
+	alertsRaised.write(w)
+	phaseDuration.write(w)
+
+	queued := 0.0
+	for _, sb := range store.list() {
+		if !sb.finished() {
+			queued++
+		}
+	}
+	writeGauge(w, "tinyhci_queue_depth", "Builds that have not completed yet.",
+		map[string]float64{"": queued})
+
+	available := make(map[string]float64)
+	for _, board := range Boards() {
+		v := 0.0
+		if _, err := os.Readlink("/dev/" + board.port); err == nil && board.enabled {
+			v = 1
+		}
+		available[formatLabels([]string{"target"}, []string{board.target})] = v
+	}
+	writeGauge(w, "tinyhci_board_available", "If the board is enabled and its device is present.",
+		available)
+
+	quarantine := make(map[string]float64)
+	for _, board := range Boards() {
+		v := 0.0
+		if _, ok := quarantined(board.target); ok {
+			v = 1
+		}
+		quarantine[formatLabels([]string{"target"}, []string{board.target})] = v
+	}
+	writeGauge(w, "tinyhci_board_quarantined", "If the board has been taken out of service after repeated infrastructure failures.",
+		quarantine)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// device returns the path of the device that the board's udev symlink
+// points to, such as /dev/ttyACM0.
+func (board *Board) device() (string, error) {
+	realdev, err := os.Readlink("/dev/" + board.port)
+	if err != nil {
+		return "", err
+	}
+	return "/dev/" + realdev, nil
+}
+
+// checkPresence checks that the board is plugged in before it is flashed:
+// its udev symlink resolves, its device can be opened, and if the board
+// has a USB ID, that the device is that USB device.
+func (board *Board) checkPresence() error {
+	dev, err := board.device()
+	if err != nil {
+		return fmt.Errorf("udev symlink /dev/%s is missing: %w", board.port, err)
+	}
+
+	f, err := os.OpenFile(dev, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", dev, err)
+	}
+	f.Close()
+
+	if board.usb == "" {
+		return nil
+	}
+
+	id, err := usbID(filepath.Base(dev))
+	if err != nil {
+		return fmt.Errorf("unable to find the USB ID of %s: %w", dev, err)
+	}
+	if id != board.usb {
+		return fmt.Errorf("%s is USB device %s, not %s", dev, id, board.usb)
+	}
+
+	return nil
+}
+
+// usbID returns the USB vendor and product ID, such as "2e8a:000a", of the
+// USB device for the tty with this name, using sysfs.
+func usbID(tty string) (string, error) {
+	dir, err := filepath.EvalSymlinks("/sys/class/tty/" + tty + "/device")
+	if err != nil {
+		return "", err
+	}
+
+	// the tty belongs to a USB interface, and the IDs are in the
+	// directory of the USB device that has the interface.
+	for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		vendor, err := os.ReadFile(filepath.Join(dir, "idVendor"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		product, err := os.ReadFile(filepath.Join(dir, "idProduct"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(vendor)) + ":" + strings.TrimSpace(string(product)), nil
+	}
+
+	return "", errors.New("not a USB device")
+}
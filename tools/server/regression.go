@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+)
+
+var (
+	// baseBranch is compared against for builds that are not for a pull request.
+	baseBranch = "dev"
+
+	// failOnRegressionsOnly only fails a board run for tests that do not
+	// also fail on the base branch.
+	failOnRegressionsOnly = false
+)
+
+// workflowRunBase returns the repo and branch that the commit is compared
+// against, which is the base branch of its pull request if it has one.
+func workflowRunBase(wr *github.WorkflowRun) string {
+	branch := baseBranch
+	if prs := wr.PullRequests; len(prs) > 0 && prs[0].GetBase().GetRef() != "" {
+		branch = prs[0].GetBase().GetRef()
+	}
+	return ghorg + "/" + ghrepo + ":" + branch
+}
+
+// comparison is how the tests in a board run did compared with the latest
+// run of the same board on the base branch.
+type comparison struct {
+	// Base is the repo and branch that was compared against.
+	Base string
+	// SHA is the commit on the base branch.
+	SHA string
+
+	// New are the tests that failed, but did not fail on the base branch.
+	New []string
+	// Existing are the tests that failed on the base branch as well.
+	Existing []string
+	// Fixed are the tests that failed on the base branch, but passed.
+	Fixed []string
+}
+
+// compare compares the test runner output with the latest results for the
+// board on the build's base branch. It returns nil if there is nothing to
+// compare against.
+func (build *Build) compare(target, tests string) *comparison {
+	branch := build.getBase()
+	if testResults == nil || branch == "" {
+		return nil
+	}
+
+	sha, base := testResults.latest(target, branch, build.sha)
+	if base == nil {
+		return nil
+	}
+
+	c := &comparison{Base: branch, SHA: sha}
+	for _, t := range parseTAP(tests) {
+		switch {
+		case t.Outcome == outcomeFail && base[t.Name] == outcomeFail:
+			c.Existing = append(c.Existing, t.Name)
+		case t.Outcome == outcomeFail:
+			c.New = append(c.New, t.Name)
+		case t.Outcome == outcomePass && base[t.Name] == outcomeFail:
+			c.Fixed = append(c.Fixed, t.Name)
+		}
+	}
+	return c
+}
+
+// latest returns the sha of the latest run of the board on the head,
+// other than for the excluded sha, and the outcome of each of its tests.
+func (s *resultStore) latest(board, head, exclude string) (string, map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sha string
+	var outcomes map[string]string
+	for i := len(s.results) - 1; i >= 0; i-- {
+		r := s.results[i]
+		if r.Board != board || r.Head != head || r.SHA == exclude {
+			continue
+		}
+		if sha == "" {
+			sha = r.SHA
+			outcomes = make(map[string]string)
+		}
+		if r.SHA != sha {
+			break
+		}
+
+		// the results are in order, so the first one seen is the last attempt
+		if _, ok := outcomes[r.Test]; !ok {
+			outcomes[r.Test] = r.Outcome
+		}
+	}
+	return sha, outcomes
+}
+
+// branch returns the name of the base branch, such as "dev".
+func (c *comparison) branch() string {
+	_, branch, _ := strings.Cut(c.Base, ":")
+	return branch
+}
+
+// markdown returns the comparison as a section of a check run report.
+func (c *comparison) markdown() string {
+	out := "## Compared with " + c.branch() + " (" + c.SHA[:7] + ")\n\n"
+	if len(c.New) == 0 && len(c.Existing) == 0 && len(c.Fixed) == 0 {
+		return out + "Same results as " + c.branch() + ".\n\n"
+	}
+
+	list := func(heading string, tests []string) string {
+		if len(tests) == 0 {
+			return ""
+		}
+		sort.Strings(tests)
+		s := "**" + heading + "**\n\n"
+		for _, t := range tests {
+			s += "- " + t + "\n"
+		}
+		return s + "\n"
+	}
+	out += list("New failures in this commit", c.New)
+	out += list("Already failing on "+c.branch(), c.Existing)
+	out += list("Fixed by this commit", c.Fixed)
+	return out
+}
+
+// regressed returns true if any tests failed that did not fail on the base branch.
+func (c *comparison) regressed() bool {
+	return len(c.New) > 0
+}
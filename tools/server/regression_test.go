@@ -0,0 +1,179 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useResults sets up a result store for the test with these results in it.
+func useResults(t *testing.T, results ...testResult) *resultStore {
+	s, err := openResultStore(t.TempDir() + "/results.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.add(results); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := testResults
+	testResults = s
+	t.Cleanup(func() {
+		testResults = prev
+		s.f.Close()
+	})
+	return s
+}
+
+func TestLatest(t *testing.T) {
+	const dev = "tinygo-org/tinygo:dev"
+	result := func(sha, head, board, test, outcome string) testResult {
+		return testResult{Time: time.Now(), SHA: sha, Head: head, Board: board, Test: test, Outcome: outcome}
+	}
+
+	tests := []struct {
+		name    string
+		results []testResult
+		exclude string
+		sha     string
+		want    map[string]string
+	}{
+		{
+			name: "no results",
+		},
+		{
+			name: "other branch",
+			results: []testResult{
+				result("aaa", "tinygo-org/tinygo:release", "pico", "digital", outcomePass),
+			},
+		},
+		{
+			name: "other board",
+			results: []testResult{
+				result("aaa", dev, "microbit", "digital", outcomePass),
+			},
+		},
+		{
+			name: "latest run",
+			results: []testResult{
+				result("aaa", dev, "pico", "digital", outcomeFail),
+				result("aaa", dev, "pico", "i2c", outcomeFail),
+				result("bbb", dev, "pico", "digital", outcomePass),
+				result("bbb", dev, "microbit", "digital", outcomeFail),
+			},
+			sha:  "bbb",
+			want: map[string]string{"digital": outcomePass},
+		},
+		{
+			name: "last attempt",
+			results: []testResult{
+				result("aaa", dev, "pico", "digital", outcomeFail),
+				result("aaa", dev, "pico", "digital", outcomePass),
+			},
+			sha:  "aaa",
+			want: map[string]string{"digital": outcomePass},
+		},
+		{
+			name: "excluded",
+			results: []testResult{
+				result("aaa", dev, "pico", "digital", outcomeFail),
+				result("bbb", dev, "pico", "digital", outcomePass),
+			},
+			exclude: "bbb",
+			sha:     "aaa",
+			want:    map[string]string{"digital": outcomeFail},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useResults(t, tt.results...)
+			sha, got := s.latest("pico", dev, tt.exclude)
+			if sha != tt.sha || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("latest() = %q, %v, want %q, %v", sha, got, tt.sha, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	const dev = "tinygo-org/tinygo:dev"
+	base := []testResult{
+		{SHA: "aaa", Head: dev, Board: "pico", Test: "digital", Outcome: outcomePass},
+		{SHA: "aaa", Head: dev, Board: "pico", Test: "i2c", Outcome: outcomeFail},
+		{SHA: "aaa", Head: dev, Board: "pico", Test: "spi", Outcome: outcomeFail},
+	}
+
+	tests := []struct {
+		name    string
+		base    string
+		results []testResult
+		out     string
+		want    *comparison
+	}{
+		{
+			name:    "no base branch",
+			results: base,
+			out:     "ok 1 - digital\n",
+		},
+		{
+			name: "no base results",
+			base: dev,
+			out:  "ok 1 - digital\n",
+		},
+		{
+			name:    "same results",
+			base:    dev,
+			results: base,
+			out:     "ok 1 - digital\nnot ok 2 - i2c\nnot ok 3 - spi\n",
+			want:    &comparison{Base: dev, SHA: "aaa", Existing: []string{"i2c", "spi"}},
+		},
+		{
+			name:    "new failure",
+			base:    dev,
+			results: base,
+			out:     "not ok 1 - digital\nnot ok 2 - i2c\nnot ok 3 - spi\n",
+			want:    &comparison{Base: dev, SHA: "aaa", New: []string{"digital"}, Existing: []string{"i2c", "spi"}},
+		},
+		{
+			name:    "fixed",
+			base:    dev,
+			results: base,
+			out:     "ok 1 - digital\nok 2 - i2c\nnot ok 3 - spi # SKIP no pin\n",
+			want:    &comparison{Base: dev, SHA: "aaa", Fixed: []string{"i2c"}},
+		},
+		{
+			name:    "new test",
+			base:    dev,
+			results: base,
+			out:     "ok 1 - digital\nnot ok 2 - i2c\nnot ok 3 - spi\nnot ok 4 - adc\n",
+			want:    &comparison{Base: dev, SHA: "aaa", New: []string{"adc"}, Existing: []string{"i2c", "spi"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useResults(t, tt.results...)
+			build := NewBuild("bbb")
+			build.base = tt.base
+
+			got := build.compare("pico", tt.out)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compare() = %+v, want %+v", got, tt.want)
+			}
+			if got != nil && got.regressed() != (len(tt.want.New) > 0) {
+				t.Errorf("regressed() = %v, want %v", got.regressed(), len(tt.want.New) > 0)
+			}
+		})
+	}
+}
+
+func TestCompareNoResultStore(t *testing.T) {
+	prev := testResults
+	testResults = nil
+	t.Cleanup(func() { testResults = prev })
+
+	build := NewBuild("bbb")
+	build.base = "tinygo-org/tinygo:dev"
+	if got := build.compare("pico", "not ok 1 - digital\n"); got != nil {
+		t.Errorf("compare() = %+v, want nil", got)
+	}
+}
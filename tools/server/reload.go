@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// how often to check if the boards config file has changed
+const boardsPollInterval = 10 * time.Second
+
+// watchBoards reloads the boards config file when the server receives
+// a SIGHUP, or when the file has been modified.
+func watchBoards(filename string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	modtime := fileModTime(filename)
+	ticker := time.NewTicker(boardsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			slog.Info("received SIGHUP, reloading boards")
+		case <-ticker.C:
+			mt := fileModTime(filename)
+			if mt.Equal(modtime) {
+				continue
+			}
+			slog.Info("boards config file changed, reloading boards")
+		}
+
+		modtime = fileModTime(filename)
+		reloadBoards(filename)
+	}
+}
+
+// reloadBoards loads the boards config file and then replaces all of the
+// boards at once. If the file is not valid, the current boards are kept.
+func reloadBoards(filename string) {
+	newboards, err := loadBoards(filename)
+	if err != nil {
+		slog.Error("unable to reload boards, keeping current config", "err", err)
+		return
+	}
+
+	for _, board := range newboards {
+		old := GetBoard(board.target)
+		switch {
+		case old == nil:
+			slog.Info("board added", "target", board.target, "enabled", board.enabled)
+		case old.enabled && !board.enabled:
+			slog.Info("board disabled", "target", board.target)
+		case !old.enabled && board.enabled:
+			slog.Info("board enabled", "target", board.target)
+		}
+	}
+
+	setBoards(newboards)
+	slog.Info("loaded boards", "count", len(newboards), "file", filename)
+}
+
+// fileModTime returns the modification time of the file, or the zero
+// time if it does not exist.
+func fileModTime(filename string) time.Time {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
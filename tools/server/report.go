@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// GitHub only accepts this many annotations in each update of a check run
+	maxAnnotations = 50
+
+	// GitHub only accepts this many characters in the summary or the text
+	// of a check run
+	maxOutputText = 65535
+
+	// room kept in the report for each note about trimmed output
+	trimNoteRoom = 100
+
+	// the least flash output kept for each attempt when the report is too long
+	minFlashOutput = 2000
+)
+
+// boardReport returns the report for all of the attempts of a board run,
+// which fits in the text of a check run. The test output is kept whole if
+// it fits, and the flash output is trimmed in the middle to make it fit.
+// The complete output is in the run log.
+func (build *Build) boardReport(board *Board, cmp *comparison, attempts []*attempt) string {
+	head := boardHeading(board)
+	run, _ := build.run(board.target)
+	if url := runLogURL(build.sha, board.target, run); url != "" {
+		head += "The complete flash and test output is in the [full log](" + url + "/full).\n\n"
+	}
+	if cmp != nil {
+		head += cmp.markdown()
+	}
+
+	// the size of everything but the output itself
+	n := len(attempts)
+	size := len(head)
+	flashSize, testsSize := 0, 0
+	for _, a := range attempts {
+		if n > 1 {
+			size += len(attemptHeading(a))
+		}
+		size += len(flashout("")) + trimNoteRoom
+		flashSize = max(flashSize, len(a.flash))
+		if a.tests != "" {
+			size += len(testsout("")) + trimNoteRoom
+			testsSize = max(testsSize, len(a.tests))
+		}
+	}
+
+	// how much of the output of each attempt to keep
+	room := max(maxOutputText-size, 0)
+	flashBudget, testsBudget := flashSize, testsSize
+	switch {
+	case (flashSize+testsSize)*n <= room:
+		// everything fits
+	case (testsSize+minFlashOutput)*n <= room:
+		flashBudget = room/n - testsSize
+	default:
+		flashBudget = min(minFlashOutput, room/(2*n))
+		testsBudget = room/n - flashBudget
+	}
+
+	report := head
+	for _, a := range attempts {
+		if n > 1 {
+			report += attemptHeading(a)
+		}
+		report += flashout(trimOutput(a.flash, flashBudget))
+		if a.tests != "" {
+			report += testsout(trimOutput(a.tests, testsBudget))
+		}
+	}
+	return report
+}
+
+// trimOutput returns the output if it is no longer than limit. Otherwise
+// it keeps the start and the end, and replaces the middle with a note.
+func trimOutput(out string, limit int) string {
+	if len(out) <= limit {
+		return out
+	}
+
+	note := func(trimmed int) string {
+		return fmt.Sprintf("\n... %d characters trimmed, see the full log ...\n", trimmed)
+	}
+
+	// the note is never longer than this, since no more than all of it is trimmed
+	keep := max(limit-len(note(len(out))), 0)
+	end := keep / 2
+	start := len(out) - (keep - end)
+
+	// prefer to cut between lines, and never in the middle of a character
+	if i := strings.LastIndexByte(out[:end], '\n'); i > end/2 {
+		end = i + 1
+	}
+	if i := strings.IndexByte(out[start:], '\n'); i >= 0 && i < (len(out)-start)/2 {
+		start += i + 1
+	}
+	for end > 0 && !utf8.RuneStart(out[end]) {
+		end--
+	}
+	for start < len(out) && !utf8.RuneStart(out[start]) {
+		start++
+	}
+
+	return out[:end] + note(start-end) + out[start:]
+}
+
+// Annotation points at a line in the tests for a board.
+type Annotation struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Level   string `json:"level"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// withTests adds a table of the test results to the summary of the result,
+// and an annotation for each test that failed.
+func withTests(target string, result Result, tests []tapTest) Result {
+	if len(tests) == 0 {
+		return result
+	}
+
+	result.Summary += "\n\n" + testsTable(tests)
+	for _, t := range tests {
+		if t.Outcome != outcomeFail || len(result.Annotations) == maxAnnotations {
+			continue
+		}
+		result.Annotations = append(result.Annotations, testAnnotation(target, t))
+	}
+	return result
+}
+
+// testsTable returns the test results as a Markdown table.
+func testsTable(tests []tapTest) string {
+	cell := strings.NewReplacer("|", `\|`, "\n", "<br>")
+	status := map[string]string{
+		outcomePass: "✅ pass",
+		outcomeFail: "❌ fail",
+		outcomeSkip: "⏭️ skip",
+		outcomeTodo: "🚧 todo",
+	}
+
+	table := "| Status | Test | Diagnostics |\n| --- | --- | --- |\n"
+	for _, t := range tests {
+		table += "| " + status[t.Outcome] +
+			" | " + cell.Replace(t.Name) +
+			" | " + cell.Replace(strings.Join(t.Diagnostics, "\n")) + " |\n"
+	}
+	return table
+}
+
+// testAnnotation returns an annotation for the failed test, which points
+// at the function for the test in the board's main.go, such as spiTxRx
+// in pico/main.go.
+func testAnnotation(target string, t tapTest) Annotation {
+	path := target + "/main.go"
+	fn, _, _ := strings.Cut(t.Name, " ")
+
+	message := strings.Join(t.Diagnostics, "\n")
+	if message == "" {
+		message = "The test failed."
+	}
+
+	return Annotation{
+		Path:    path,
+		Line:    findFunc(path, fn),
+		Level:   "failure",
+		Title:   t.Name + " failed",
+		Message: message,
+	}
+}
+
+// findFunc returns the line that the function is declared on in the file,
+// or 1 if it cannot be found.
+func findFunc(path, fn string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+
+	decl := regexp.MustCompile(`^func\s+` + regexp.QuoteMeta(fn) + `\s*\(`)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if decl.MatchString(scanner.Text()) {
+			return line
+		}
+	}
+	return 1
+}
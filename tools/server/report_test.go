@@ -0,0 +1,300 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTestsTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		tests []tapTest
+		want  string
+	}{
+		{
+			name: "outcomes",
+			tests: []tapTest{
+				{Number: 1, Name: "digital", Outcome: outcomePass},
+				{Number: 2, Name: "i2c", Outcome: outcomeFail, Diagnostics: []string{"no ack"}},
+				{Number: 3, Name: "spi", Outcome: outcomeSkip, Diagnostics: []string{"SKIP no pin"}},
+				{Number: 4, Name: "pwm", Outcome: outcomeTodo},
+			},
+			want: "| Status | Test | Diagnostics |\n| --- | --- | --- |\n" +
+				"| ✅ pass | digital |  |\n" +
+				"| ❌ fail | i2c | no ack |\n" +
+				"| ⏭️ skip | spi | SKIP no pin |\n" +
+				"| 🚧 todo | pwm |  |\n",
+		},
+		{
+			name: "escaped",
+			tests: []tapTest{
+				{Number: 1, Name: "a|b", Outcome: outcomeFail, Diagnostics: []string{"got: 1", "want: 2|3"}},
+			},
+			want: "| Status | Test | Diagnostics |\n| --- | --- | --- |\n" +
+				`| ❌ fail | a\|b | got: 1<br>want: 2\|3 |` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testsTable(tt.tests); got != tt.want {
+				t.Errorf("testsTable() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTests(t *testing.T) {
+	result := Result{Conclusion: "failure", Summary: "Hardware CI tests have failed."}
+
+	t.Run("no tests", func(t *testing.T) {
+		got := withTests("pico", result, nil)
+		if got.Summary != result.Summary || len(got.Annotations) != 0 {
+			t.Errorf("withTests() = %+v, want the result unchanged", got)
+		}
+	})
+
+	t.Run("annotations", func(t *testing.T) {
+		tests := []tapTest{
+			{Number: 1, Name: "digital", Outcome: outcomePass},
+			{Number: 2, Name: "i2c", Outcome: outcomeFail},
+			{Number: 3, Name: "spi", Outcome: outcomeSkip},
+			{Number: 4, Name: "adc", Outcome: outcomeFail},
+		}
+		got := withTests("pico", result, tests)
+		if !strings.HasPrefix(got.Summary, result.Summary+"\n\n| Status |") {
+			t.Errorf("summary does not have the tests table after it:\n%s", got.Summary)
+		}
+		var titles []string
+		for _, a := range got.Annotations {
+			titles = append(titles, a.Title)
+		}
+		if want := []string{"i2c failed", "adc failed"}; strings.Join(titles, ",") != strings.Join(want, ",") {
+			t.Errorf("annotations = %v, want %v", titles, want)
+		}
+	})
+
+	t.Run("too many failures", func(t *testing.T) {
+		var tests []tapTest
+		for i := range maxAnnotations + 10 {
+			tests = append(tests, tapTest{Number: i + 1, Name: "test", Outcome: outcomeFail})
+		}
+		got := withTests("pico", result, tests)
+		if len(got.Annotations) != maxAnnotations {
+			t.Errorf("got %d annotations, want %d", len(got.Annotations), maxAnnotations)
+		}
+	})
+}
+
+func TestTestAnnotation(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "pico")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := "package main\n" +
+		"\n" +
+		"func main() {\n" +
+		"}\n" +
+		"\n" +
+		"func spiTxRx() {\n" +
+		"}\n" +
+		"\n" +
+		"func spiTxRxLong () {\n" +
+		"}\n"
+	if err := os.WriteFile(target+"/main.go", []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		test    tapTest
+		line    int
+		message string
+	}{
+		{
+			name:    "function",
+			test:    tapTest{Name: "spiTxRx", Outcome: outcomeFail, Diagnostics: []string{"got: 0", "want: 1"}},
+			line:    6,
+			message: "got: 0\nwant: 1",
+		},
+		{
+			name:    "function and description",
+			test:    tapTest{Name: "spiTxRxLong (SPI0 loopback)", Outcome: outcomeFail},
+			line:    9,
+			message: "The test failed.",
+		},
+		{
+			name:    "unknown function",
+			test:    tapTest{Name: "uartEcho", Outcome: outcomeFail},
+			line:    1,
+			message: "The test failed.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := testAnnotation(target, tt.test)
+			want := Annotation{
+				Path:    target + "/main.go",
+				Line:    tt.line,
+				Level:   "failure",
+				Title:   tt.test.Name + " failed",
+				Message: tt.message,
+			}
+			if got != want {
+				t.Errorf("testAnnotation() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFindFuncMissingFile(t *testing.T) {
+	if got := findFunc(filepath.Join(t.TempDir(), "main.go"), "main"); got != 1 {
+		t.Errorf("findFunc() = %d, want 1", got)
+	}
+}
+
+func TestTrimOutput(t *testing.T) {
+	lines := strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz\n", 1000)
+	tests := []struct {
+		name  string
+		out   string
+		limit int
+	}{
+		{name: "short", out: "flashed", limit: 100},
+		{name: "exact", out: strings.Repeat("x", 100), limit: 100},
+		{name: "no lines", out: strings.Repeat("x", 10000), limit: 1000},
+		{name: "lines", out: lines, limit: 1000},
+		{name: "multibyte", out: strings.Repeat("é✓", 5000), limit: 999},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimOutput(tt.out, tt.limit)
+			if len(tt.out) <= tt.limit {
+				if got != tt.out {
+					t.Errorf("trimOutput() = %q, want the output unchanged", got)
+				}
+				return
+			}
+
+			if len(got) > tt.limit {
+				t.Errorf("trimOutput() is %d long, want at most %d", len(got), tt.limit)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("trimOutput() cut a character in half")
+			}
+			before, after, ok := strings.Cut(got, "\n... ")
+			if !ok || !strings.Contains(after, " characters trimmed, see the full log ...\n") {
+				t.Fatalf("trimOutput() has no note about the trimmed output:\n%s", got)
+			}
+			_, after, _ = strings.Cut(after, "...\n")
+			if !strings.HasPrefix(tt.out, before) || !strings.HasSuffix(tt.out, after) {
+				t.Errorf("trimOutput() does not keep the start and the end of the output")
+			}
+			if tt.out == lines && (!strings.HasSuffix(before, "\n") || !strings.HasPrefix(after, "0123")) {
+				t.Errorf("trimOutput() did not cut between lines:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestBoardReport(t *testing.T) {
+	board := &Board{target: "pico", displayname: "Raspberry Pi Pico"}
+	build := NewBuild("0123456789abcdef")
+	build.setRun("pico", 42)
+
+	prev := publicURL
+	t.Cleanup(func() { publicURL = prev })
+
+	long := func(s string, n int) string {
+		return strings.Repeat(s+"\n", n/(len(s)+1))
+	}
+
+	tests := []struct {
+		name      string
+		publicURL string
+		attempts  []*attempt
+		want      string
+
+		// if want is empty, the report only has to contain these
+		contains []string
+		trimmed  bool
+	}{
+		{
+			name:     "passed",
+			attempts: []*attempt{{number: 1, flash: "flashed", tests: "ok 1 - digital"}},
+			want: "## Raspberry Pi Pico\n\n" +
+				"## Flash\n\n```\nflashed\n```\n\n" +
+				"## Tests\n\n```\nok 1 - digital\n```\n\n",
+		},
+		{
+			name:      "log link",
+			publicURL: "https://hci.example.com",
+			attempts:  []*attempt{{number: 1, kind: failureBuild, flash: "main.go:1:1: error"}},
+			want: "## Raspberry Pi Pico\n\n" +
+				"The complete flash and test output is in the [full log](https://hci.example.com/logs/0123456789abcdef/pico/42/full).\n\n" +
+				"## Flash\n\n```\nmain.go:1:1: error\n```\n\n",
+		},
+		{
+			name: "retried",
+			attempts: []*attempt{
+				{number: 1, kind: failureFlash, flash: "bossac: failed"},
+				{number: 2, flash: "flashed", tests: "ok 1 - digital"},
+			},
+			want: "## Raspberry Pi Pico\n\n" +
+				"## Attempt 1: flash failure\n\n" +
+				"## Flash\n\n```\nbossac: failed\n```\n\n" +
+				"## Attempt 2: passed\n\n" +
+				"## Flash\n\n```\nflashed\n```\n\n" +
+				"## Tests\n\n```\nok 1 - digital\n```\n\n",
+		},
+		{
+			name: "long flash output",
+			attempts: []*attempt{
+				{number: 1, flash: long("flash output", 200000), tests: long("ok 1 - digital", 20000)},
+			},
+			contains: []string{long("ok 1 - digital", 20000)},
+			trimmed:  true,
+		},
+		{
+			name: "long flash output retried",
+			attempts: []*attempt{
+				{number: 1, kind: failureInfra, flash: long("flash output", 200000)},
+				{number: 2, kind: failureInfra, flash: long("flash output", 200000)},
+				{number: 3, flash: long("flash output", 200000), tests: long("ok 1 - digital", 10000)},
+			},
+			contains: []string{"## Attempt 3: passed", long("ok 1 - digital", 10000)},
+			trimmed:  true,
+		},
+		{
+			name: "long test output",
+			attempts: []*attempt{
+				{number: 1, flash: long("flash output", 200000), tests: long("ok 1 - digital", 200000)},
+			},
+			contains: []string{"## Tests"},
+			trimmed:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publicURL = tt.publicURL
+			got := build.boardReport(board, nil, tt.attempts)
+
+			if len(got) > maxOutputText {
+				t.Errorf("report is %d long, want at most %d", len(got), maxOutputText)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("boardReport() =\n%s\nwant\n%s", got, tt.want)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Errorf("report does not contain %.40q", s)
+				}
+			}
+			if trimmed := strings.Contains(got, "characters trimmed"); trimmed != tt.trimmed {
+				t.Errorf("report trimmed = %v, want %v", trimmed, tt.trimmed)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reporter reports the progress and results of the runs for each
+// board in a build.
+type Reporter interface {
+	// Pending creates a new run for the target and returns its ID.
+	Pending(build *Build, target string) (int64, error)
+
+	// Start marks the run as in progress.
+	Start(build *Build, target string, id int64) error
+
+	// Requeue moves the run back to queued, with the reason it did not
+	// finish this time. It will be started again later.
+	Requeue(build *Build, target string, id int64, reason string) error
+
+	// Complete finishes the run with the result.
+	Complete(build *Build, target string, id int64, result Result) error
+}
+
+// Result is the outcome of a run on a board.
+type Result struct {
+	// Conclusion uses the same values as a GitHub check run, such as
+	// "success", "failure", "neutral" or "cancelled".
+	Conclusion string `json:"conclusion"`
+	Title      string `json:"title"`
+	Summary    string `json:"summary"`
+	Text       string `json:"text"`
+
+	// Annotations point at the tests that failed.
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+var reporter Reporter = githubReporter{}
+
+// newReporter returns the reporter for the kind, which is either "github",
+// "console", or "json:" followed by the name of the file to write to.
+func newReporter(kind string) (Reporter, error) {
+	switch {
+	case kind == "" || kind == "github":
+		return githubReporter{}, nil
+	case kind == "console":
+		return &consoleReporter{}, nil
+	case strings.HasPrefix(kind, "json:"):
+		return newJSONReporter(strings.TrimPrefix(kind, "json:"))
+	}
+	return nil, errors.New("unknown reporter " + kind)
+}
+
+func (build *Build) pendingCheckSuite() {
+	build.log().Info("check suite pending")
+	for _, board := range Boards() {
+		if board.enabled {
+			build.pendingCheckRun(board.target)
+		}
+	}
+	build.save()
+}
+
+func (build *Build) pendingCheckRun(target string) {
+	build.log().Info("check run pending", "target", target)
+	id, err := reporter.Pending(build, target)
+	if err != nil {
+		build.log().Error("unable to create check run", "target", target, "err", err)
+		return
+	}
+	build.setRun(target, id)
+}
+
+func (build *Build) startCheckSuite() {
+	build.log().Info("check suite starting")
+	for _, target := range build.targets() {
+		build.startCheckRun(target)
+	}
+}
+
+func (build *Build) startCheckRun(target string) {
+	build.log().Info("check run starting", "target", target)
+	if id, ok := build.run(target); ok {
+		if err := reporter.Start(build, target, id); err != nil {
+			build.log().Error("unable to start check run", "target", target, "err", err)
+		}
+	}
+}
+
+// passCheckRun passes the check run for the target. The tests are the
+// results from the test runner, if it ran.
+func (build *Build) passCheckRun(target, output string, tests ...tapTest) {
+	build.log().Info("check run passed", "target", target)
+	build.completeCheckRun(target, withTests(target, Result{
+		Conclusion: "success",
+		Title:      "Hardware CI passed",
+		Summary:    "Hardware CI tests have passed.",
+		Text:       output,
+	}, tests))
+}
+
+func (build *Build) failCheckSuite(output string) {
+	build.log().Info("check suite failed")
+	for _, target := range build.targets() {
+		build.failCheckRun(target, output)
+	}
+}
+
+// failCheckRun fails the check run for the target. The tests are the
+// results from the test runner, if it ran.
+func (build *Build) failCheckRun(target, output string, tests ...tapTest) {
+	build.log().Info("check run failed", "target", target)
+	build.completeCheckRun(target, withTests(target, Result{
+		Conclusion: "failure",
+		Title:      "Hardware CI failed",
+		Summary:    "Hardware CI tests have failed.",
+		Text:       output,
+	}, tests))
+}
+
+func (build *Build) cancelCheckSuite(output string) {
+	build.log().Info("check suite cancelled")
+	for _, target := range build.targets() {
+		build.cancelCheckRun(target, output)
+	}
+}
+
+func (build *Build) cancelCheckRun(target, output string) {
+	build.log().Info("check run cancelled", "target", target)
+	build.completeCheckRun(target, Result{
+		Conclusion: "cancelled",
+		Title:      "Hardware CI cancelled",
+		Summary:    "Hardware CI tests were cancelled.",
+		Text:       output,
+	})
+}
+
+func (build *Build) timeoutCheckSuite(phase string, limit time.Duration) {
+	build.log().Info("check suite timed out")
+	for _, target := range build.targets() {
+		build.timeoutCheckRun(target, phase, limit, "")
+	}
+}
+
+func (build *Build) timeoutCheckRun(target, phase string, limit time.Duration, output string, tests ...tapTest) {
+	build.log().Info("check run timed out", "target", target, "phase", phase)
+	build.completeCheckRun(target, withTests(target, Result{
+		Conclusion: "timed_out",
+		Title:      "Hardware CI timed out during " + phase,
+		Summary:    fmt.Sprintf("The %s did not finish within %s, so it was stopped.", phase, limit),
+		Text:       output,
+	}, tests))
+}
+
+func (build *Build) infraCheckRun(target string, kind failureKind, output string) {
+	build.log().Info("check run could not complete", "target", target, "kind", kind)
+	build.completeCheckRun(target, Result{
+		Conclusion: "neutral",
+		Title:      "Hardware CI could not run the tests",
+		Summary:    "The tests could not be run because of a " + string(kind) + " problem with the test hardware, which is not a failure in TinyGo.",
+		Text:       output,
+	})
+}
+
+func (build *Build) requeueCheckSuite(reason string) {
+	build.log().Info("check suite requeued")
+	for _, target := range build.targets() {
+		build.requeueCheckRun(target, reason)
+	}
+}
+
+// requeueCheckRun moves the check run for the target back to queued. Unlike
+// the other results, the run stays in the build so that it is run again
+// when the build is resumed.
+func (build *Build) requeueCheckRun(target, reason string) {
+	build.log().Info("check run requeued", "target", target)
+	if id, ok := build.run(target); ok {
+		if err := reporter.Requeue(build, target, id, reason); err != nil {
+			build.log().Error("unable to requeue check run", "target", target, "err", err)
+		}
+		if state, _ := build.runState(target); state != RunPending {
+			build.setRunState(target, RunPending)
+		}
+		build.save()
+	}
+}
+
+func (build *Build) offlineCheckRun(board *Board, err error) {
+	build.log().Info("check run skipped, board offline", "target", board.target)
+	build.completeCheckRun(board.target, Result{
+		Conclusion: "skipped",
+		Title:      "Hardware CI board offline",
+		Summary:    "The " + board.displayname + " board is offline, so the tests were not run. This is not a failure in TinyGo, and the TinyHCI maintainers have been alerted.",
+		Text:       err.Error(),
+	})
+}
+
+func (build *Build) quarantinedCheckRun(board *Board, reason string) {
+	build.log().Info("check run skipped, board quarantined", "target", board.target)
+	build.completeCheckRun(board.target, Result{
+		Conclusion: "skipped",
+		Title:      "Hardware CI board quarantined",
+		Summary:    "The " + board.displayname + " board has been taken out of service after repeated problems with the test hardware, so the tests were not run. This is not a failure in TinyGo. The board returns to service once its health checks pass again.",
+		Text:       reason,
+	})
+}
+
+func (build *Build) knownFailuresCheckRun(target string, cmp *comparison, output string, tests ...tapTest) {
+	build.log().Info("check run has no new failures", "target", target, "existing", len(cmp.Existing))
+	build.completeCheckRun(target, withTests(target, Result{
+		Conclusion: "neutral",
+		Title:      "Hardware CI found no new failures",
+		Summary:    "The tests that failed also fail on " + cmp.branch() + ", so they were not caused by this commit.",
+		Text:       output,
+	}, tests))
+}
+
+// completeCheckRun reports the result for the target, and removes its run
+// from the build.
+func (build *Build) completeCheckRun(target string, result Result) {
+	result.Summary = trimOutput(result.Summary, maxOutputText)
+	result.Text = trimOutput(result.Text, maxOutputText)
+
+	if id, ok := build.run(target); ok {
+		if err := reporter.Complete(build, target, id, result); err != nil {
+			build.log().Error("unable to complete check run", "target", target, "err", err)
+		}
+		build.finishRun(target, result.Conclusion)
+		setLastResult(target, build.sha, result.Conclusion)
+		build.save()
+	}
+}
+
+// consoleReporter prints the results to stdout, for running locally.
+type consoleReporter struct {
+	mu     sync.Mutex
+	lastID int64
+}
+
+func (r *consoleReporter) Pending(build *Build, target string) (int64, error) {
+	r.mu.Lock()
+	r.lastID++
+	id := r.lastID
+	r.mu.Unlock()
+
+	r.printf("[%s] %s: pending\n", build.sha[:7], target)
+	return id, nil
+}
+
+func (r *consoleReporter) Start(build *Build, target string, id int64) error {
+	r.printf("[%s] %s: in progress\n", build.sha[:7], target)
+	return nil
+}
+
+func (r *consoleReporter) Requeue(build *Build, target string, id int64, reason string) error {
+	r.printf("[%s] %s: queued - %s\n", build.sha[:7], target, reason)
+	return nil
+}
+
+func (r *consoleReporter) Complete(build *Build, target string, id int64, result Result) error {
+	r.printf("[%s] %s: %s - %s\n\n%s\n", build.sha[:7], target, result.Conclusion, result.Summary, result.Text)
+	return nil
+}
+
+func (r *consoleReporter) printf(format string, a ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fmt.Printf(format, a...)
+}
+
+// jsonReporter appends every event as a line of JSON to a file,
+// for running locally and in tests.
+type jsonReporter struct {
+	mu     sync.Mutex
+	f      *os.File
+	lastID int64
+}
+
+// jsonEvent is a single line written by the jsonReporter.
+type jsonEvent struct {
+	Time   time.Time `json:"time"`
+	SHA    string    `json:"sha"`
+	Target string    `json:"target"`
+	ID     int64     `json:"id"`
+	Status string    `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+	Result *Result   `json:"result,omitempty"`
+}
+
+func newJSONReporter(filename string) (*jsonReporter, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonReporter{f: f}, nil
+}
+
+func (r *jsonReporter) Pending(build *Build, target string) (int64, error) {
+	r.mu.Lock()
+	r.lastID++
+	id := r.lastID
+	r.mu.Unlock()
+
+	return id, r.write(jsonEvent{SHA: build.sha, Target: target, ID: id, Status: "queued"})
+}
+
+func (r *jsonReporter) Start(build *Build, target string, id int64) error {
+	return r.write(jsonEvent{SHA: build.sha, Target: target, ID: id, Status: "in_progress"})
+}
+
+func (r *jsonReporter) Requeue(build *Build, target string, id int64, reason string) error {
+	return r.write(jsonEvent{SHA: build.sha, Target: target, ID: id, Status: "queued", Reason: reason})
+}
+
+func (r *jsonReporter) Complete(build *Build, target string, id int64, result Result) error {
+	return r.write(jsonEvent{SHA: build.sha, Target: target, ID: id, Status: "completed", Result: &result})
+}
+
+func (r *jsonReporter) write(ev jsonEvent) error {
+	ev.Time = time.Now()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.f.Write(append(data, '\n'))
+	return err
+}
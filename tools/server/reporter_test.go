@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewReporter(t *testing.T) {
+	tests := []struct {
+		kind    string
+		want    string
+		wantErr bool
+	}{
+		{kind: "", want: "github"},
+		{kind: "github", want: "github"},
+		{kind: "console", want: "console"},
+		{kind: "json:" + filepath.Join(t.TempDir(), "events.json"), want: "json"},
+		{kind: "json:" + filepath.Join(t.TempDir(), "missing", "events.json"), wantErr: true},
+		{kind: "slack", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := newReporter(tt.kind)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("newReporter(%q) did not return an error", tt.kind)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newReporter(%q) returned error: %v", tt.kind, err)
+			continue
+		}
+
+		var got string
+		switch r.(type) {
+		case githubReporter:
+			got = "github"
+		case *consoleReporter:
+			got = "console"
+		case *jsonReporter:
+			got = "json"
+		}
+		if got != tt.want {
+			t.Errorf("newReporter(%q) = %T, want %s reporter", tt.kind, r, tt.want)
+		}
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "events.json")
+	r, err := newJSONReporter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := reporter
+	reporter = r
+	t.Cleanup(func() { reporter = prev })
+
+	build := NewBuild("0123456789abcdef")
+	build.pendingCheckRun("pico")
+	build.pendingCheckRun("microbit")
+	build.startCheckRun("pico")
+	build.requeueCheckRun("pico", "server restarted")
+	build.passCheckRun("pico", "all tests passed")
+	build.cancelCheckRun("microbit", "cancelled by @someone")
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var events []jsonEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev jsonEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, ev)
+	}
+
+	want := []struct {
+		target     string
+		id         int64
+		status     string
+		reason     string
+		conclusion string
+	}{
+		{target: "pico", id: 1, status: "queued"},
+		{target: "microbit", id: 2, status: "queued"},
+		{target: "pico", id: 1, status: "in_progress"},
+		{target: "pico", id: 1, status: "queued", reason: "server restarted"},
+		{target: "pico", id: 1, status: "completed", conclusion: "success"},
+		{target: "microbit", id: 2, status: "completed", conclusion: "cancelled"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		ev := events[i]
+		if ev.SHA != build.sha || ev.Target != w.target || ev.ID != w.id ||
+			ev.Status != w.status || ev.Reason != w.reason {
+			t.Errorf("event %d = %+v, want %+v", i, ev, w)
+		}
+		var conclusion string
+		if ev.Result != nil {
+			conclusion = ev.Result.Conclusion
+		}
+		if conclusion != w.conclusion {
+			t.Errorf("event %d conclusion = %q, want %q", i, conclusion, w.conclusion)
+		}
+	}
+
+	if targets := build.targets(); len(targets) != 0 {
+		t.Errorf("build still has check runs for %v", targets)
+	}
+	if got := build.conclusion(); got != "cancelled" {
+		t.Errorf("build conclusion = %q, want %q", got, "cancelled")
+	}
+}
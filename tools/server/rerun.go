@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+
+	"github.com/google/go-github/v84/github"
+)
+
+// Identifiers of the buttons on completed check runs.
+const (
+	actionRerun        = "rerun"
+	actionRerunVerbose = "rerun-verbose"
+	actionRerunSuite   = "rerun-suite"
+)
+
+// checkRunActions are the buttons shown on every completed check run.
+var checkRunActions = []*github.CheckRunAction{
+	{
+		Label:       "Re-run this board",
+		Description: "Flash and test this board again",
+		Identifier:  actionRerun,
+	},
+	{
+		Label:       "Re-run verbose",
+		Description: "Re-run this board with more diagnostics",
+		Identifier:  actionRerunVerbose,
+	},
+	{
+		Label:       "Re-run all boards",
+		Description: "Flash and test every board again",
+		Identifier:  actionRerunSuite,
+	},
+}
+
+// tarballFile returns the name of the TinyGo binary downloaded for the sha.
+func tarballFile(sha string) string {
+	return "tools/docker/versions/" + sha + ".tar.gz"
+}
+
+// imageExists returns true if the docker image for the sha has already been built.
+func imageExists(ctx context.Context, sha string) bool {
+	return exec.CommandContext(ctx, "docker", "image", "inspect", "tinygohci:"+sha[:7]).Run() == nil
+}
+
+// runOptions change how the boards in a build are run.
+type runOptions struct {
+	// verbose adds the build commands and the full size report to the
+	// flash output.
+	verbose bool
+
+	// allBoards runs boards that are disabled or quarantined as well.
+	allBoards bool
+}
+
+// errBuildNotFinished is returned by rerun if the build for the sha is
+// still waiting for CI, queued or running.
+var errBuildNotFinished = errors.New("the build for this commit has not finished yet")
+
+// rerun queues a new build for the sha, with new check runs for the targets.
+// The TinyGo binary and docker image from the earlier build are used again
+// if they are still there.
+func rerun(sha string, targets []string, opts runOptions, buildsCh chan *Build) error {
+	prev, ok := builds.get(sha)
+	if ok && !prev.getState().finished() {
+		return errBuildNotFinished
+	}
+
+	build := NewBuild(sha)
+	build.options = opts
+	if ok {
+		build.received = prev.received
+		build.head = prev.getHead()
+		build.base = prev.getBase()
+		build.workflowRun = prev.getWorkflowRun()
+
+		// keep the results of the boards that are not run again
+		for target, r := range prev.runResults() {
+			if !slices.Contains(targets, target) {
+				build.results[target] = r
+			}
+		}
+	}
+
+	cached := useCurrentBinaryRelease || fileExists(tarballFile(sha))
+	if !ok || (build.workflowRun == 0 && !cached) {
+		wr, err := getRecentWorkflowRunForSHA("success", sha)
+		if err != nil {
+			return err
+		}
+		build.setWorkflowRun(wr)
+	}
+
+	// another re-run may have been queued while looking up the workflow run
+	if !builds.replaceFinished(build) {
+		return errBuildNotFinished
+	}
+	buildsReceived.inc()
+	build.log().Info("re-running boards", "targets", targets,
+		"verbose", opts.verbose, "all_boards", opts.allBoards)
+	for _, target := range targets {
+		build.pendingCheckRun(target)
+	}
+	build.save()
+
+	// handoff to channel for processing. The build processor can be busy
+	// with another build for a long time, so the webhook does not wait.
+	go func() { buildsCh <- build }()
+	return nil
+}
+
+// rerunNotStarted marks the check run that a re-run was requested from as
+// neutral, with the reason that the board was not re-run, since GitHub does
+// not show anything for a re-run that did not start. The earlier output of
+// the check run is kept after the reason.
+func rerunNotStarted(cr *github.CheckRun, target string) {
+	build, ok := builds.get(cr.GetHeadSHA())
+	if !ok {
+		return
+	}
+
+	summary := "The build for this commit has not finished yet, so the board was not re-run. " +
+		"Request the re-run again once all of the boards have finished.\n\n" +
+		"The earlier run concluded with **" + cr.GetConclusion() + "**.\n\n" + cr.GetOutput().GetSummary()
+	result := Result{
+		Conclusion: "neutral",
+		Title:      "Hardware CI re-run not started",
+		Summary:    trimOutput(summary, maxOutputText),
+		Text:       cr.GetOutput().GetText(),
+	}
+	if err := reporter.Complete(build, target, cr.GetID(), result); err != nil {
+		build.log().Error("unable to complete check run", "target", target, "err", err)
+	}
+}
+
+// failRerun creates check runs for the targets that fail right away with
+// the reason that the build could not be re-run, so that a re-run that is
+// not possible does not just leave the old check runs as they were.
+func failRerun(sha string, targets []string, err error) {
+	build := NewBuild(sha)
+	if !builds.replaceFinished(build) {
+		return
+	}
+	buildsReceived.inc()
+	for _, target := range targets {
+		build.pendingCheckRun(target)
+	}
+	build.failCheckSuite("TinyHCI could not re-run the boards for this commit: " + err.Error() + ".")
+	build.finish()
+}
+
+// awaitCI creates pending check runs for all of the enabled boards for
+// the build, which then waits for the CI workflow for its commit to finish.
+func awaitCI(build *Build) {
+	buildsReceived.inc()
+	build.pendingCheckSuite()
+}
+
+// queueAfterCI queues the build once the CI workflow run for its commit
+// has finished, unless a newer commit for the same branch has arrived since.
+func queueAfterCI(build *Build, wr *github.WorkflowRun, buildsCh chan *Build) {
+	build.setWorkflowRun(wr)
+	build.save()
+
+	// the CI for an older commit can finish after a newer one
+	if newer, ok := newerBuild(build); ok {
+		build.cancel(fmt.Sprintf("Superseded by newer commit %s.", newer.sha), false)
+		return
+	}
+
+	// cancel any older builds for the same branch
+	supersede(build)
+
+	// handoff to channel for processing, without making the webhook wait
+	// for the build processor
+	go func() { buildsCh <- build }()
+}
+
+// enabledTargets returns the targets of all of the enabled boards.
+func enabledTargets() []string {
+	var targets []string
+	for _, board := range Boards() {
+		if board.enabled {
+			targets = append(targets, board.target)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v84/github"
+)
+
+func TestRerunNotFinished(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "events.json")
+	r, err := newJSONReporter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := reporter
+	reporter = r
+	t.Cleanup(func() {
+		reporter = prev
+		r.f.Close()
+	})
+
+	const sha = "0123456789abcdef"
+	useBuilds(t, testBuild(sha, "tinygo-org/tinygo:dev", 0, BuildRunning))
+
+	// the pico board has finished, and the others are still running
+	err = rerun(sha, []string{"pico"}, runOptions{}, make(chan *Build, 1))
+	if !errors.Is(err, errBuildNotFinished) {
+		t.Fatalf("rerun() = %v, want %v", err, errBuildNotFinished)
+	}
+
+	rerunNotStarted(&github.CheckRun{
+		ID:         github.Ptr(int64(42)),
+		HeadSHA:    github.Ptr(sha),
+		Conclusion: github.Ptr("failure"),
+		Output: &github.CheckRunOutput{
+			Summary: github.Ptr("## Raspberry Pi Pico"),
+			Text:    github.Ptr("not ok 1 - i2c"),
+		},
+	}, "pico")
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var events []jsonEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev jsonEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1: %+v", len(events), events)
+	}
+
+	ev := events[0]
+	if ev.ID != 42 || ev.Target != "pico" || ev.Status != "completed" || ev.Result == nil {
+		t.Fatalf("event = %+v, want check run 42 for pico completed", ev)
+	}
+	if ev.Result.Conclusion != "neutral" {
+		t.Errorf("conclusion = %q, want %q", ev.Result.Conclusion, "neutral")
+	}
+	if !strings.HasPrefix(ev.Result.Summary, "The build for this commit has not finished yet") ||
+		!strings.HasSuffix(ev.Result.Summary, "**failure**.\n\n## Raspberry Pi Pico") {
+		t.Errorf("summary does not have the reason and the earlier summary:\n%s", ev.Result.Summary)
+	}
+	if ev.Result.Text != "not ok 1 - i2c" {
+		t.Errorf("text = %q, want the earlier text", ev.Result.Text)
+	}
+}
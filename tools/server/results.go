@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how many runs of a test its failure rate is calculated over by default
+const defaultStatsRuns = 100
+
+// testResult is the result of a single test in one attempt of a board run.
+type testResult struct {
+	Time     time.Time     `json:"time"`
+	SHA      string        `json:"sha"`
+	Head     string        `json:"head,omitempty"`
+	Board    string        `json:"board"`
+	Test     string        `json:"test"`
+	Outcome  string        `json:"outcome"`
+	Duration time.Duration `json:"duration"`
+	Attempt  int           `json:"attempt"`
+}
+
+// testKey identifies a test on a board.
+type testKey struct {
+	Board string
+	Test  string
+}
+
+// flakyTest is a test that both passed and failed for the same sha.
+type flakyTest struct {
+	Board    string    `json:"board"`
+	Test     string    `json:"test"`
+	SHA      string    `json:"sha"`
+	Detected time.Time `json:"detected"`
+}
+
+// testStats are the recent results of a test on a board.
+type testStats struct {
+	Board       string  `json:"board"`
+	Test        string  `json:"test"`
+	Runs        int     `json:"runs"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"`
+	Flaky       bool    `json:"flaky"`
+}
+
+// resultStore keeps the result of every test that has been run, appending
+// each one as a line of JSON to a file in the working directory.
+type resultStore struct {
+	mu sync.Mutex
+	f  *os.File
+
+	// results are in the order they were run.
+	results []testResult
+
+	// outcomes are the outcomes seen for each test for each sha.
+	outcomes map[string]map[string]bool
+
+	// flaky are the tests that have been flagged as flaky.
+	flaky map[testKey]flakyTest
+}
+
+var testResults *resultStore
+
+// openResultStore opens the store in filename, loading all of the
+// results already in it.
+func openResultStore(filename string) (*resultStore, error) {
+	s := &resultStore{
+		outcomes: make(map[string]map[string]bool),
+		flaky:    make(map[testKey]flakyTest),
+	}
+
+	f, err := os.Open(filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			var r testResult
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+			}
+			s.index(r)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.f, err = os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// add saves the results, and flags any tests that are now flaky.
+func (s *resultStore) add(results []testResult) error {
+	var data []byte
+	for _, r := range results {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range results {
+		if s.index(r) {
+			slog.Warn("flaky test detected", "sha", r.SHA, "target", r.Board, "test", r.Test)
+		}
+	}
+
+	_, err := s.f.Write(data)
+	return err
+}
+
+// index adds the result to the store in memory. It returns true if this
+// result is the first sign that the test is flaky.
+func (s *resultStore) index(r testResult) bool {
+	s.results = append(s.results, r)
+	if r.Outcome != outcomePass && r.Outcome != outcomeFail {
+		return false
+	}
+
+	id := r.SHA + "\x00" + r.Board + "\x00" + r.Test
+	if s.outcomes[id] == nil {
+		s.outcomes[id] = make(map[string]bool)
+	}
+	s.outcomes[id][r.Outcome] = true
+	if len(s.outcomes[id]) < 2 {
+		return false
+	}
+
+	key := testKey{Board: r.Board, Test: r.Test}
+	_, already := s.flaky[key]
+	s.flaky[key] = flakyTest{Board: r.Board, Test: r.Test, SHA: r.SHA, Detected: r.Time}
+	return !already
+}
+
+// stats returns how often the test failed on the board in its last runs.
+// Skipped tests do not count as runs.
+func (s *resultStore) stats(board, test string, runs int) testStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := testStats{Board: board, Test: test}
+	for i := len(s.results) - 1; i >= 0 && st.Runs < runs; i-- {
+		r := s.results[i]
+		if r.Board != board || r.Test != test {
+			continue
+		}
+		switch r.Outcome {
+		case outcomeFail:
+			st.Failures++
+			st.Runs++
+		case outcomePass:
+			st.Runs++
+		}
+	}
+	if st.Runs > 0 {
+		st.FailureRate = float64(st.Failures) / float64(st.Runs)
+	}
+	_, st.Flaky = s.flaky[testKey{Board: board, Test: test}]
+
+	return st
+}
+
+// flakyTests returns all of the tests that have been flagged as flaky,
+// most recently detected first.
+func (s *resultStore) flakyTests() []flakyTest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]flakyTest, 0, len(s.flaky))
+	for _, ft := range s.flaky {
+		result = append(result, ft)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Detected.After(result[j].Detected)
+	})
+
+	return result
+}
+
+// recordTests saves the results of the tests in the test runner output
+// for one attempt of a board run, if there is a result store.
+func (build *Build) recordTests(target string, attempt int, out string) {
+	if testResults == nil {
+		return
+	}
+
+	now := time.Now()
+	head := build.getHead()
+	var results []testResult
+	for _, t := range parseTAP(out) {
+		results = append(results, testResult{
+			Time:     now,
+			SHA:      build.sha,
+			Head:     head,
+			Board:    target,
+			Test:     t.Name,
+			Outcome:  t.Outcome,
+			Duration: t.Duration,
+			Attempt:  attempt,
+		})
+	}
+	if len(results) == 0 {
+		return
+	}
+
+	if err := testResults.add(results); err != nil {
+		build.log().Error("unable to save test results", "target", target, "err", err)
+	}
+}
+
+// handleTestStats returns the failure rate of a test on a board, such as
+// /tests/stats?board=pico&test=spiTxRx+(SPI)&runs=100
+func handleTestStats(w http.ResponseWriter, r *http.Request) {
+	board, test := r.FormValue("board"), r.FormValue("test")
+	if board == "" || test == "" {
+		http.Error(w, "board and test are required", http.StatusBadRequest)
+		return
+	}
+
+	runs := defaultStatsRuns
+	if v := r.FormValue("runs"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "runs must be a positive number", http.StatusBadRequest)
+			return
+		}
+		runs = n
+	}
+
+	writeJSON(w, testResults.stats(board, test, runs))
+}
+
+// handleFlakyTests returns all of the tests that have been flagged as flaky.
+func handleFlakyTests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, testResults.flakyTests())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("unable to write JSON response", "err", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResultStats(t *testing.T) {
+	result := func(sha, board, test, outcome string) testResult {
+		return testResult{SHA: sha, Board: board, Test: test, Outcome: outcome}
+	}
+
+	tests := []struct {
+		name    string
+		results []testResult
+		runs    int
+		want    testStats
+	}{
+		{
+			name: "no results",
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c"},
+		},
+		{
+			name: "failure rate",
+			results: []testResult{
+				result("aaa", "pico", "i2c", outcomePass),
+				result("bbb", "pico", "i2c", outcomeFail),
+				result("ccc", "pico", "i2c", outcomePass),
+				result("ddd", "pico", "i2c", outcomePass),
+			},
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 4, Failures: 1, FailureRate: 0.25},
+		},
+		{
+			name: "other boards and tests",
+			results: []testResult{
+				result("aaa", "pico", "i2c", outcomeFail),
+				result("aaa", "microbit", "i2c", outcomePass),
+				result("aaa", "pico", "spi", outcomePass),
+			},
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 1, Failures: 1, FailureRate: 1},
+		},
+		{
+			name: "skipped and todo are not runs",
+			results: []testResult{
+				result("aaa", "pico", "i2c", outcomeSkip),
+				result("bbb", "pico", "i2c", outcomePass),
+				result("ccc", "pico", "i2c", outcomeTodo),
+			},
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 1},
+		},
+		{
+			name: "latest runs only",
+			results: []testResult{
+				result("aaa", "pico", "i2c", outcomeFail),
+				result("bbb", "pico", "i2c", outcomeFail),
+				result("ccc", "pico", "i2c", outcomePass),
+				result("ddd", "pico", "i2c", outcomeFail),
+			},
+			runs: 2,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 2, Failures: 1, FailureRate: 0.5},
+		},
+		{
+			name: "flaky",
+			results: []testResult{
+				result("aaa", "pico", "i2c", outcomeFail),
+				result("aaa", "pico", "i2c", outcomePass),
+			},
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 2, Failures: 1, FailureRate: 0.5, Flaky: true},
+		},
+		{
+			name: "failing on different commits is not flaky",
+			results: []testResult{
+				result("aaa", "pico", "i2c", outcomeFail),
+				result("bbb", "pico", "i2c", outcomePass),
+			},
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 2, Failures: 1, FailureRate: 0.5},
+		},
+		{
+			name: "failing and skipped is not flaky",
+			results: []testResult{
+				result("aaa", "pico", "i2c", outcomeFail),
+				result("aaa", "pico", "i2c", outcomeSkip),
+			},
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 1, Failures: 1, FailureRate: 1},
+		},
+		{
+			name: "flaky on another board",
+			results: []testResult{
+				result("aaa", "microbit", "i2c", outcomeFail),
+				result("aaa", "microbit", "i2c", outcomePass),
+				result("aaa", "pico", "i2c", outcomePass),
+			},
+			runs: defaultStatsRuns,
+			want: testStats{Board: "pico", Test: "i2c", Runs: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useResults(t, tt.results...)
+			if got := s.stats("pico", "i2c", tt.runs); got != tt.want {
+				t.Errorf("stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlakyTests(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	results := []testResult{
+		{Time: at(0), SHA: "aaa", Board: "pico", Test: "i2c", Outcome: outcomeFail},
+		{Time: at(1), SHA: "aaa", Board: "pico", Test: "i2c", Outcome: outcomePass},
+		{Time: at(2), SHA: "aaa", Board: "pico", Test: "spi", Outcome: outcomePass},
+		{Time: at(3), SHA: "bbb", Board: "microbit", Test: "adc", Outcome: outcomePass},
+		{Time: at(4), SHA: "bbb", Board: "microbit", Test: "adc", Outcome: outcomeFail},
+		{Time: at(5), SHA: "ccc", Board: "pico", Test: "i2c", Outcome: outcomeFail},
+		{Time: at(6), SHA: "ccc", Board: "pico", Test: "i2c", Outcome: outcomePass},
+	}
+	s := useResults(t, results...)
+
+	// the i2c test is flagged again by the later commit
+	want := []flakyTest{
+		{Board: "pico", Test: "i2c", SHA: "ccc", Detected: at(6)},
+		{Board: "microbit", Test: "adc", SHA: "bbb", Detected: at(4)},
+	}
+	got := s.flakyTests()
+	if len(got) != len(want) {
+		t.Fatalf("flakyTests() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("flakyTests()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// the results are loaded again when the store is opened
+	s.f.Close()
+	reopened, err := openResultStore(s.f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.f.Close()
+	if got := reopened.flakyTests(); len(got) != len(want) || !got[0].Detected.Equal(want[0].Detected) {
+		t.Errorf("flakyTests() after reopening = %+v, want %+v", got, want)
+	}
+}
+
+func TestHandleTestStats(t *testing.T) {
+	useResults(t,
+		testResult{SHA: "aaa", Board: "pico", Test: "spiTxRx (SPI)", Outcome: outcomeFail},
+		testResult{SHA: "bbb", Board: "pico", Test: "spiTxRx (SPI)", Outcome: outcomePass},
+	)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   testStats
+	}{
+		{
+			name:   "stats",
+			query:  "board=pico&test=spiTxRx+(SPI)",
+			status: http.StatusOK,
+			want:   testStats{Board: "pico", Test: "spiTxRx (SPI)", Runs: 2, Failures: 1, FailureRate: 0.5},
+		},
+		{
+			name:   "runs",
+			query:  "board=pico&test=spiTxRx+(SPI)&runs=1",
+			status: http.StatusOK,
+			want:   testStats{Board: "pico", Test: "spiTxRx (SPI)", Runs: 1},
+		},
+		{
+			name:   "no test",
+			query:  "board=pico",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "bad runs",
+			query:  "board=pico&test=spiTxRx+(SPI)&runs=0",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleTestStats(w, httptest.NewRequest("GET", "/tests/stats?"+tt.query, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got testStats
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+const runUsage = `Usage: tinygohci run --tinygo <file or url> [--board target,...]
+
+Flashes and tests the boards with a TinyGo linux-amd64 release, doing the
+same thing as the server does for a build, and prints the report for each
+board. It must be run from the root of the tinyhci repo.
+
+`
+
+// runCommand handles the "run" command, which flashes and tests boards
+// locally without GitHub.
+func runCommand(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), runUsage)
+		fs.PrintDefaults()
+	}
+	tinygo := fs.String("tinygo", "", "TinyGo linux-amd64 tarball to test, either a file or a URL")
+	boardlist := fs.String("board", "", "comma separated list of board targets to test, even if they are disabled (default all enabled boards)")
+	bf := fs.String("boards", boardsfile, "boards config file")
+	fs.Parse(args)
+
+	if *tinygo == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if !fileExists("build/testrunner") {
+		log.Fatal("build/testrunner not found, run 'make testrunner' first")
+	}
+
+	bs, err := loadBoards(*bf)
+	if err != nil {
+		log.Fatal("Unable to load boards: ", err)
+	}
+
+	targets, err := selectBoards(bs, *boardlist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setBoards(bs)
+
+	sha, err := prepareBinary(*tinygo)
+	if err != nil {
+		log.Fatal("Unable to get TinyGo: ", err)
+	}
+
+	slog.Info("building docker image", "tinygo", *tinygo)
+	if err := buildDocker(context.Background(), sha); err != nil {
+		log.Fatal("Docker build failed: ", err)
+	}
+
+	cr := &countingReporter{Reporter: &consoleReporter{}}
+	reporter = cr
+
+	build := NewBuild(sha)
+	for _, target := range targets {
+		build.pendingCheckRun(target)
+	}
+	build.startCheckSuite()
+	build.runBoards()
+
+	if cr.failed > 0 {
+		slog.Error("boards failed", "failed", cr.failed, "boards", len(targets))
+		os.Exit(1)
+	}
+}
+
+// selectBoards returns the targets in the comma separated list, or all of
+// the enabled boards if the list is empty. Boards in the list are enabled,
+// so that disabled boards can be tested too.
+func selectBoards(bs []*Board, list string) ([]string, error) {
+	var targets []string
+	if list == "" {
+		for _, board := range bs {
+			if board.enabled {
+				targets = append(targets, board.target)
+			}
+		}
+		return targets, nil
+	}
+
+	for _, target := range strings.Split(list, ",") {
+		target = strings.TrimSpace(target)
+		found := false
+		for _, board := range bs {
+			if board.target == target {
+				board.enabled = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown board %q", target)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// prepareBinary puts the TinyGo tarball where the docker build expects it,
+// either by downloading it or by copying the local file. It returns the
+// SHA-256 of the location, which is used in place of the commit SHA.
+func prepareBinary(location string) (string, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		sum := sha256.Sum256([]byte(location))
+		sha := hex.EncodeToString(sum[:])
+
+		slog.Info("downloading TinyGo", "url", location)
+		return sha, downloadBinary(location, sha)
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sha := hex.EncodeToString(h.Sum(nil))
+
+	dest := tarballFile(sha)
+	if fileExists(dest) {
+		return sha, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	out, err := os.Create(dest + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, f); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	return sha, os.Rename(dest+".tmp", dest)
+}
+
+// countingReporter counts the runs that did not succeed.
+type countingReporter struct {
+	Reporter
+
+	mu     sync.Mutex
+	failed int
+}
+
+func (r *countingReporter) Complete(build *Build, target string, id int64, result Result) error {
+	if result.Conclusion != "success" {
+		r.mu.Lock()
+		r.failed++
+		r.mu.Unlock()
+	}
+	return r.Reporter.Complete(build, target, id, result)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// how long the boards that are running get to finish when the server stops
+const defaultShutdownGrace = 5 * time.Minute
+
+const (
+	shutdownReason    = "TinyHCI was restarted before this board was tested. It will be tested once TinyHCI is running again."
+	interruptedReason = "TinyHCI was restarted while this board was being tested. It will be tested again once TinyHCI is running again."
+)
+
+var (
+	// draining is set once the server has started shutting down.
+	draining atomic.Bool
+
+	// stopping is closed to tell processBuilds to stop taking builds off
+	// the queue, and it closes stopped once the current build is done.
+	stopping = make(chan struct{})
+	stopped  = make(chan struct{})
+)
+
+// shuttingDown returns true once the server has started shutting down.
+// No new builds or board runs are started after that.
+func shuttingDown() bool {
+	return draining.Load()
+}
+
+// waitForShutdown waits for a SIGTERM or an interrupt, and then shuts down
+// the server. The boards that are running get the grace period to finish,
+// and are then stopped. Any check runs that did not finish are queued again,
+// and run once the server has been started again.
+func waitForShutdown(server *http.Server, grace time.Duration) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+
+	s := <-sig
+	slog.Info("shutting down", "signal", s, "grace", grace)
+	draining.Store(true)
+	close(stopping)
+
+	select {
+	case <-stopped:
+	case <-time.After(grace):
+		slog.Warn("boards did not finish within the grace period, stopping them")
+		for _, build := range builds.all() {
+			if build.getState().active() {
+				build.stop()
+			}
+		}
+		<-stopped
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("unable to shut down HTTP server", "err", err)
+	}
+}
+
+// interrupted returns true if the build was stopped because the server
+// is shutting down, rather than because it was cancelled.
+func (build *Build) interrupted() bool {
+	if build.ctx.Err() == nil {
+		return false
+	}
+	_, cancelled := build.cancelled()
+	return !cancelled
+}
+
+// requeue puts the build back in the queue when the server is shutting
+// down, so that its remaining check runs are resumed once it starts again.
+func (build *Build) requeue() {
+	build.log().Info("requeueing build", "remaining", build.targets())
+	if err := build.requeueState(); err != nil {
+		build.log().Error("unable to requeue build", "err", err)
+	}
+	build.save()
+	build.closeLog()
+}
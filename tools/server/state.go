@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// BuildState is the state of a Build.
+type BuildState string
+
+const (
+	// BuildAwaitingCI is waiting for the TinyGo CI build to finish.
+	BuildAwaitingCI BuildState = "awaiting-ci"
+	// BuildQueued has a TinyGo binary and is waiting to be tested.
+	BuildQueued BuildState = "queued"
+	// BuildDownloading is downloading the TinyGo binary.
+	BuildDownloading BuildState = "downloading"
+	// BuildBuildingImage is building the docker image with the TinyGo binary.
+	BuildBuildingImage BuildState = "building-image"
+	// BuildRunning is being tested on the hardware.
+	BuildRunning BuildState = "running"
+	// BuildCompleted has finished all of its check runs.
+	BuildCompleted BuildState = "completed"
+	// BuildCancelled was stopped before all of its check runs finished,
+	// such as when a newer commit for the same branch arrived.
+	BuildCancelled BuildState = "cancelled"
+)
+
+// buildTransitions are the states that a build can move to from each state.
+// A build that was interrupted by the server stopping is queued again with
+// requeueState instead. A finished build is never started again, since a
+// re-run is a new build.
+var buildTransitions = map[BuildState][]BuildState{
+	BuildAwaitingCI:    {BuildQueued, BuildCancelled},
+	BuildQueued:        {BuildDownloading, BuildCompleted, BuildCancelled},
+	BuildDownloading:   {BuildBuildingImage, BuildCompleted, BuildCancelled},
+	BuildBuildingImage: {BuildRunning, BuildCompleted, BuildCancelled},
+	BuildRunning:       {BuildCompleted, BuildCancelled},
+	BuildCompleted:     {},
+	BuildCancelled:     {},
+}
+
+// finished returns true if the build has completed or was cancelled.
+func (s BuildState) finished() bool {
+	return s == BuildCompleted || s == BuildCancelled
+}
+
+// active returns true if the build has been taken off the queue,
+// and has not finished yet.
+func (s BuildState) active() bool {
+	switch s {
+	case BuildDownloading, BuildBuildingImage, BuildRunning:
+		return true
+	}
+	return false
+}
+
+// RunState is the state of the check run for a single board in a Build.
+type RunState string
+
+const (
+	// RunPending has a check run, and has not been handed to the board yet.
+	RunPending RunState = "pending"
+	// RunQueued is waiting for the board to finish any earlier runs.
+	RunQueued RunState = "queued"
+	// RunFlashing is flashing the tests to the board.
+	RunFlashing RunState = "flashing"
+	// RunResetWait is waiting for the board to reset after flashing.
+	RunResetWait RunState = "waiting-for-reset"
+	// RunTesting is running the tests on the board.
+	RunTesting RunState = "testing"
+)
+
+// runTransitions are the states that a board run can move to from each state.
+// Once the check run has completed, the board run is removed from the build.
+// A run that is interrupted when the server shuts down goes back to pending.
+var runTransitions = map[RunState][]RunState{
+	RunPending:   {RunQueued},
+	RunQueued:    {RunFlashing, RunPending},
+	RunFlashing:  {RunResetWait, RunFlashing, RunPending},
+	RunResetWait: {RunTesting, RunPending},
+	// a failed attempt is retried by flashing the board again
+	RunTesting: {RunFlashing, RunPending},
+}
+
+// getState returns the state of the build.
+func (build *Build) getState() BuildState {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	return build.state
+}
+
+// getQueued returns when the build was queued to be tested.
+func (build *Build) getQueued() time.Time {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	return build.queued
+}
+
+// transition moves the build to the new state. It returns an error, and
+// leaves the state as it is, if the build cannot move to that state.
+func (build *Build) transition(to BuildState) error {
+	build.mu.Lock()
+	from := build.state
+	if !slices.Contains(buildTransitions[from], to) {
+		build.mu.Unlock()
+		return fmt.Errorf("invalid build state transition from %s to %s", from, to)
+	}
+	build.state = to
+	if to == BuildQueued {
+		build.queued = time.Now()
+	}
+	build.mu.Unlock()
+
+	build.log().Info("build state changed", "from", from, "to", to)
+	return nil
+}
+
+// requeueState moves a build that was interrupted while it was active back
+// to queued, so that it starts again from the download. It is only for
+// builds that were stopped by the server shutting down or restarting.
+func (build *Build) requeueState() error {
+	build.mu.Lock()
+	from := build.state
+	if !from.active() {
+		build.mu.Unlock()
+		return fmt.Errorf("cannot requeue build in state %s", from)
+	}
+	build.state = BuildQueued
+	build.queued = time.Now()
+	build.mu.Unlock()
+
+	build.log().Info("build state changed", "from", from, "to", BuildQueued)
+	return nil
+}
+
+// runState returns the state of the board run for this target.
+func (build *Build) runState(target string) (RunState, bool) {
+	build.mu.Lock()
+	defer build.mu.Unlock()
+
+	state, ok := build.runStates[target]
+	return state, ok
+}
+
+// setRunState moves the board run for this target to the new state,
+// and shows it in the board's status. An invalid transition is logged,
+// and the state is left as it is.
+func (build *Build) setRunState(target string, to RunState) {
+	build.mu.Lock()
+	from, ok := build.runStates[target]
+	if !ok || !slices.Contains(runTransitions[from], to) {
+		build.mu.Unlock()
+		build.log().Error("invalid board run state transition", "target", target, "from", from, "to", to)
+		return
+	}
+	build.runStates[target] = to
+	build.mu.Unlock()
+
+	build.log().Info("board run state changed", "target", target, "from", from, "to", to)
+
+	// the board is not working on this run while it is pending or queued
+	switch to {
+	case RunPending, RunQueued:
+	default:
+		setPhase(target, build.sha, string(to))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var allBuildStates = []BuildState{
+	BuildAwaitingCI,
+	BuildQueued,
+	BuildDownloading,
+	BuildBuildingImage,
+	BuildRunning,
+	BuildCompleted,
+	BuildCancelled,
+}
+
+func TestBuildTransition(t *testing.T) {
+	// valid are the transitions that are allowed, all others are not
+	valid := map[[2]BuildState]bool{
+		{BuildAwaitingCI, BuildQueued}:         true,
+		{BuildAwaitingCI, BuildCancelled}:      true,
+		{BuildQueued, BuildDownloading}:        true,
+		{BuildQueued, BuildCompleted}:          true,
+		{BuildQueued, BuildCancelled}:          true,
+		{BuildDownloading, BuildBuildingImage}: true,
+		{BuildDownloading, BuildCompleted}:     true,
+		{BuildDownloading, BuildCancelled}:     true,
+		{BuildBuildingImage, BuildRunning}:     true,
+		{BuildBuildingImage, BuildCompleted}:   true,
+		{BuildBuildingImage, BuildCancelled}:   true,
+		{BuildRunning, BuildCompleted}:         true,
+		{BuildRunning, BuildCancelled}:         true,
+	}
+
+	for _, from := range allBuildStates {
+		for _, to := range allBuildStates {
+			build := NewBuild("0123456789abcdef")
+			build.state = from
+
+			err := build.transition(to)
+			want := valid[[2]BuildState{from, to}]
+			switch {
+			case want && err != nil:
+				t.Errorf("transition from %s to %s returned error: %v", from, to, err)
+			case !want && err == nil:
+				t.Errorf("transition from %s to %s is not allowed, but did not return an error", from, to)
+			}
+
+			wantState := from
+			if want {
+				wantState = to
+			}
+			if got := build.getState(); got != wantState {
+				t.Errorf("after transition from %s to %s, state = %s, want %s", from, to, got, wantState)
+			}
+		}
+	}
+}
+
+func TestBuildRequeueState(t *testing.T) {
+	tests := []struct {
+		from BuildState
+		ok   bool
+	}{
+		{BuildAwaitingCI, false},
+		{BuildQueued, false},
+		{BuildDownloading, true},
+		{BuildBuildingImage, true},
+		{BuildRunning, true},
+		{BuildCompleted, false},
+		{BuildCancelled, false},
+	}
+	for _, tt := range tests {
+		build := NewBuild("0123456789abcdef")
+		build.state = tt.from
+
+		err := build.requeueState()
+		if tt.ok != (err == nil) {
+			t.Errorf("requeueState from %s returned %v, want ok %v", tt.from, err, tt.ok)
+		}
+
+		want := tt.from
+		if tt.ok {
+			want = BuildQueued
+		}
+		if got := build.getState(); got != want {
+			t.Errorf("after requeueState from %s, state = %s, want %s", tt.from, got, want)
+		}
+	}
+}
+
+func TestBuildStateFinished(t *testing.T) {
+	for _, s := range allBuildStates {
+		want := s == BuildCompleted || s == BuildCancelled
+		if got := s.finished(); got != want {
+			t.Errorf("%s.finished() = %v, want %v", s, got, want)
+		}
+		if s.finished() && s.active() {
+			t.Errorf("%s is both finished and active", s)
+		}
+		if len(buildTransitions[s]) == 0 && !s.finished() {
+			t.Errorf("%s has no transitions, but has not finished", s)
+		}
+	}
+}
+
+func TestRunStateTransition(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []RunState
+		want  RunState
+	}{
+		{
+			name:  "passes",
+			steps: []RunState{RunQueued, RunFlashing, RunResetWait, RunTesting},
+			want:  RunTesting,
+		},
+		{
+			name:  "retries flash",
+			steps: []RunState{RunQueued, RunFlashing, RunFlashing, RunResetWait},
+			want:  RunResetWait,
+		},
+		{
+			name:  "retries after tests",
+			steps: []RunState{RunQueued, RunFlashing, RunResetWait, RunTesting, RunFlashing},
+			want:  RunFlashing,
+		},
+		{
+			name:  "interrupted",
+			steps: []RunState{RunQueued, RunFlashing, RunResetWait, RunPending},
+			want:  RunPending,
+		},
+		{
+			name:  "cannot test without flashing",
+			steps: []RunState{RunQueued, RunTesting},
+			want:  RunQueued,
+		},
+		{
+			name:  "cannot flash before queued",
+			steps: []RunState{RunFlashing},
+			want:  RunPending,
+		},
+		{
+			name:  "cannot skip the reset",
+			steps: []RunState{RunQueued, RunFlashing, RunTesting},
+			want:  RunFlashing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			build := NewBuild("0123456789abcdef")
+			build.setRun("test-board", 1)
+			for _, s := range tt.steps {
+				build.setRunState("test-board", s)
+			}
+			if got, _ := build.runState("test-board"); got != tt.want {
+				t.Errorf("run state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildQueuedTime(t *testing.T) {
+	build := NewBuild("0123456789abcdef")
+	build.state = BuildAwaitingCI
+	// waiting for CI is not part of the time spent queued
+	build.received = time.Now().Add(-time.Hour)
+	build.queued = build.received
+
+	start := time.Now()
+	if err := build.transition(BuildQueued); err != nil {
+		t.Fatal(err)
+	}
+	if queued := build.getQueued(); queued.Before(start) {
+		t.Errorf("queued = %v, want the time of the transition after %v", queued, start)
+	}
+}
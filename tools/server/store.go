@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how long completed builds are kept in the store
+const storeRetention = 30 * 24 * time.Hour
+
+// buildStore persists every Build to a single JSON file in the working
+// directory, so that queued and in-flight builds survive a server restart.
+type buildStore struct {
+	mu       sync.Mutex
+	filename string
+	builds   map[string]storedBuild
+}
+
+// storedBuild is the on-disk representation of a Build.
+type storedBuild struct {
+	SHA       string     `json:"sha"`
+	Head      string     `json:"head,omitempty"`
+	Base      string     `json:"base,omitempty"`
+	State     BuildState `json:"state"`
+	Started   time.Time  `json:"started,omitzero"`
+	Completed time.Time  `json:"completed,omitzero"`
+	Received  time.Time  `json:"received,omitzero"`
+
+	// WorkflowRun is the ID of the CI workflow run that built the TinyGo
+	// binary. The download URL for its artifact is not stored, since it
+	// expires soon after it is looked up.
+	WorkflowRun int64 `json:"workflow_run,omitempty"`
+
+	// Verbose and AllBoards are the options for a re-run.
+	Verbose   bool `json:"verbose,omitempty"`
+	AllBoards bool `json:"all_boards,omitempty"`
+
+	// CancelReason is set if the build has been cancelled.
+	CancelReason string `json:"cancel_reason,omitempty"`
+
+	// Runs are the check run IDs that still need to be completed.
+	// key is the target.
+	Runs map[string]int64 `json:"runs"`
+
+	// Results are the check runs that have completed. key is the target.
+	Results map[string]RunResult `json:"results,omitempty"`
+}
+
+// openStore opens the store in filename, loading any builds already in it.
+func openStore(filename string) (*buildStore, error) {
+	s := &buildStore{
+		filename: filename,
+		builds:   make(map[string]storedBuild),
+	}
+
+	data, err := os.ReadFile(filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.builds); err != nil {
+		return nil, err
+	}
+
+	// drop builds that completed a long time ago
+	for sha, sb := range s.builds {
+		if sb.finished() && time.Since(sb.Completed) > storeRetention {
+			delete(s.builds, sha)
+		}
+	}
+
+	return s, nil
+}
+
+// put saves the current state of the build to the store.
+func (s *buildStore) put(build *Build) error {
+	sb := storedBuild{
+		SHA:       build.sha,
+		State:     build.getState(),
+		Started:   build.started,
+		Received:  build.received,
+		Verbose:   build.options.verbose,
+		AllBoards: build.options.allBoards,
+		Runs:      make(map[string]int64),
+		Results:   build.runResults(),
+	}
+	build.mu.Lock()
+	sb.Head = build.head
+	sb.Base = build.base
+	sb.Completed = build.completed
+	sb.WorkflowRun = build.workflowRun
+	build.mu.Unlock()
+	sb.CancelReason, _ = build.cancelled()
+	for _, target := range build.targets() {
+		if id, ok := build.run(target); ok {
+			sb.Runs[target] = id
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.builds[build.sha] = sb
+	return s.save()
+}
+
+// load returns all of the builds in the store.
+func (s *buildStore) load() []*Build {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*Build, 0, len(s.builds))
+	for _, sb := range s.builds {
+		build := NewBuild(sb.SHA)
+		build.head = sb.Head
+		build.base = sb.Base
+		build.workflowRun = sb.WorkflowRun
+		build.options = runOptions{
+			verbose:   sb.Verbose,
+			allBoards: sb.AllBoards,
+		}
+		build.state = sb.State
+		build.started = sb.Started
+		build.received = sb.Received
+		build.completed = sb.Completed
+		build.cancelReason = sb.CancelReason
+		for target, id := range sb.Runs {
+			build.runs[target] = id
+			build.runStates[target] = RunPending
+		}
+		for target, r := range sb.Results {
+			build.results[target] = r
+		}
+		result = append(result, build)
+	}
+
+	return result
+}
+
+// finished returns true if the build has completed or was cancelled.
+func (sb storedBuild) finished() bool {
+	return sb.State.finished()
+}
+
+// list returns all of the builds in the store, most recent first.
+func (s *buildStore) list() []storedBuild {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]storedBuild, 0, len(s.builds))
+	for _, sb := range s.builds {
+		result = append(result, sb)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started.After(result[j].Started)
+	})
+
+	return result
+}
+
+// save writes the store to disk. The file is replaced atomically, so a crash
+// while saving leaves the previous contents in place.
+func (s *buildStore) save() error {
+	data, err := json.MarshalIndent(s.builds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.filename)
+}
+
+// save persists the build to the store, if there is one.
+func (build *Build) save() {
+	if store == nil {
+		return
+	}
+
+	if err := store.put(build); err != nil {
+		build.log().Error("unable to save build", "err", err)
+	}
+}
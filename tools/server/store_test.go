@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "builds.json")
+	s, err := openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	build := NewBuild("0123456789abcdef")
+	build.state = BuildRunning
+	build.started = started
+	build.received = started.Add(-time.Hour)
+	build.head = "someone/tinygo:fix"
+	build.base = "tinygo-org/tinygo:dev"
+	build.workflowRun = 1234
+	build.options = runOptions{verbose: true, allBoards: true}
+	build.cancelReason = "Cancelled by @someone."
+	build.runs["pico"] = 11
+	build.results["microbit"] = RunResult{ID: 12, Conclusion: "success"}
+
+	if err := s.put(build); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen the store, as after a restart
+	s, err = openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := s.load()
+	if len(loaded) != 1 {
+		t.Fatalf("loaded %d builds, want 1", len(loaded))
+	}
+	got := loaded[0]
+
+	tests := []struct {
+		field     string
+		got, want any
+	}{
+		{"sha", got.sha, build.sha},
+		{"state", got.state, build.state},
+		{"started", got.started, build.started},
+		{"received", got.received, build.received},
+		{"head", got.head, build.head},
+		{"base", got.base, build.base},
+		{"workflowRun", got.workflowRun, build.workflowRun},
+		{"options", got.options, build.options},
+		{"cancelReason", got.cancelReason, build.cancelReason},
+		{"runs[pico]", got.runs["pico"], int64(11)},
+		{"runStates[pico]", got.runStates["pico"], RunPending},
+		{"results[microbit]", got.results["microbit"], RunResult{ID: 12, Conclusion: "success"}},
+		{"len(runs)", len(got.runs), 1},
+		{"len(results)", len(got.results), 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tests := []struct {
+		sha       string
+		state     BuildState
+		completed time.Time
+		kept      bool
+	}{
+		{sha: "aaaaaaaaaaaaaaaa", state: BuildAwaitingCI, kept: true},
+		{sha: "bbbbbbbbbbbbbbbb", state: BuildQueued, kept: true},
+		{sha: "cccccccccccccccc", state: BuildCompleted, completed: now.Add(-time.Hour), kept: true},
+		{sha: "dddddddddddddddd", state: BuildCompleted, completed: now.Add(-2 * storeRetention), kept: false},
+		{sha: "eeeeeeeeeeeeeeee", state: BuildCancelled, completed: now.Add(-2 * storeRetention), kept: false},
+	}
+
+	filename := filepath.Join(t.TempDir(), "builds.json")
+	s, err := openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		build := NewBuild(tt.sha)
+		build.state = tt.state
+		build.started = now.Add(-3 * storeRetention)
+		build.completed = tt.completed
+		if err := s.put(build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// builds that completed a long time ago are dropped when the store is opened
+	s, err = openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := make(map[string]*Build)
+	for _, build := range s.load() {
+		loaded[build.sha] = build
+	}
+	for _, tt := range tests {
+		build, ok := loaded[tt.sha]
+		if ok != tt.kept {
+			t.Errorf("%s build kept = %v, want %v", tt.state, ok, tt.kept)
+			continue
+		}
+		if ok && build.state != tt.state {
+			t.Errorf("state = %s, want %s", build.state, tt.state)
+		}
+	}
+}
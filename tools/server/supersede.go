@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+)
+
+// supersedeImmediately stops a running build that has been superseded
+// right away, instead of at the start of the next board run.
+var supersedeImmediately = false
+
+// workflowRunHead returns the repo and branch that the workflow run was
+// for, such as "tinygo-org/tinygo:dev". The head repo is included since
+// pull requests from forks often use the same branch names.
+func workflowRunHead(wr *github.WorkflowRun) string {
+	if wr.GetHeadBranch() == "" {
+		return ""
+	}
+	return wr.GetHeadRepository().GetFullName() + ":" + wr.GetHeadBranch()
+}
+
+// checkSuiteHead returns the repo and branch that the check suite is for,
+// in the same form as workflowRunHead, so that a build that is still
+// waiting for CI can be superseded. The head repo of a pull request from a
+// fork is only given in the API URL of its head branch.
+func checkSuiteHead(cs *github.CheckSuite, repo *github.Repository) string {
+	if cs.GetHeadBranch() == "" {
+		return ""
+	}
+	for _, pr := range cs.PullRequests {
+		head := pr.GetHead()
+		if name := repoFullName(head.GetRepo()); name != "" && head.GetSHA() == cs.GetHeadSHA() {
+			return name + ":" + head.GetRef()
+		}
+	}
+	return repo.GetFullName() + ":" + cs.GetHeadBranch()
+}
+
+// repoFullName returns the full name of the repo, such as
+// "tinygo-org/tinygo", using its API URL if the name is not set.
+func repoFullName(repo *github.Repository) string {
+	if repo.GetFullName() != "" {
+		return repo.GetFullName()
+	}
+	_, name, _ := strings.Cut(repo.GetURL(), "/repos/")
+	return name
+}
+
+// newerBuild returns a build for the same head as this build that was
+// received after it, since there is no need to run a build that has
+// already been superseded.
+func newerBuild(older *Build) (*Build, bool) {
+	head := older.getHead()
+	if head == "" {
+		return nil, false
+	}
+
+	for _, build := range builds.all() {
+		if build != older && build.getHead() == head && build.received.After(older.received) {
+			return build, true
+		}
+	}
+	return nil, false
+}
+
+// supersede cancels all of the builds for the same head as this build
+// that were received before it.
+func supersede(newer *Build) {
+	head := newer.getHead()
+	if head == "" {
+		return
+	}
+
+	for _, build := range builds.all() {
+		if build == newer || build.getHead() != head || !build.received.Before(newer.received) {
+			continue
+		}
+
+		if build.getState().finished() {
+			continue
+		}
+
+		build.cancel(fmt.Sprintf("Superseded by newer commit %s.", newer.sha), supersedeImmediately)
+	}
+}
+
+// cancel cancels the build. The check runs for a build that has not started
+// yet are cancelled right away. A running build finishes the boards that
+// have already started, unless immediate is set, in which case they are
+// stopped as well.
+func (build *Build) cancel(reason string, immediate bool) {
+	build.mu.Lock()
+	if build.cancelReason != "" {
+		build.mu.Unlock()
+		return
+	}
+	build.cancelReason = reason
+	state := build.state
+	build.mu.Unlock()
+
+	build.log().Info("cancelling build", "reason", reason)
+
+	switch {
+	case state == BuildAwaitingCI || state == BuildQueued:
+		build.cancelCheckSuite(reason)
+		build.finish()
+	case state.active() && immediate:
+		build.stop()
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useBuilds replaces the known builds for the test.
+func useBuilds(t *testing.T, newbuilds ...*Build) {
+	prev := builds
+	builds = &buildRegistry{builds: make(map[string]*Build)}
+	for _, build := range newbuilds {
+		builds.add(build)
+	}
+	t.Cleanup(func() { builds = prev })
+}
+
+// testBuild returns a build for the head that was received at the offset
+// from now, in the state.
+func testBuild(sha, head string, received time.Duration, state BuildState) *Build {
+	build := NewBuild(sha)
+	build.head = head
+	build.received = time.Now().Add(received)
+	build.state = state
+	return build
+}
+
+func TestNewerBuild(t *testing.T) {
+	const dev = "tinygo-org/tinygo:dev"
+
+	tests := []struct {
+		name   string
+		older  *Build
+		others []*Build
+		want   string
+	}{
+		{
+			name:  "no other builds",
+			older: testBuild("aaa", dev, -time.Hour, BuildQueued),
+		},
+		{
+			name:   "newer",
+			older:  testBuild("aaa", dev, -time.Hour, BuildQueued),
+			others: []*Build{testBuild("bbb", dev, -time.Minute, BuildAwaitingCI)},
+			want:   "bbb",
+		},
+		{
+			name:   "older",
+			older:  testBuild("aaa", dev, -time.Minute, BuildQueued),
+			others: []*Build{testBuild("bbb", dev, -time.Hour, BuildRunning)},
+		},
+		{
+			name:   "other branch",
+			older:  testBuild("aaa", dev, -time.Hour, BuildQueued),
+			others: []*Build{testBuild("bbb", "tinygo-org/tinygo:release", -time.Minute, BuildQueued)},
+		},
+		{
+			name:   "fork with the same branch",
+			older:  testBuild("aaa", dev, -time.Hour, BuildQueued),
+			others: []*Build{testBuild("bbb", "someone/tinygo:dev", -time.Minute, BuildQueued)},
+		},
+		{
+			name:   "no head",
+			older:  testBuild("aaa", "", -time.Hour, BuildQueued),
+			others: []*Build{testBuild("bbb", "", -time.Minute, BuildQueued)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBuilds(t, append(tt.others, tt.older)...)
+
+			got, ok := newerBuild(tt.older)
+			if ok != (tt.want != "") || (ok && got.sha != tt.want) {
+				t.Errorf("newerBuild() = %v, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupersede(t *testing.T) {
+	const dev = "tinygo-org/tinygo:dev"
+	prev := reporter
+	reporter = &consoleReporter{}
+	t.Cleanup(func() { reporter = prev })
+
+	tests := []struct {
+		name      string
+		older     *Build
+		immediate bool
+
+		// what happens to the older build
+		cancelled bool
+		state     BuildState
+		stopped   bool
+	}{
+		{
+			name:      "awaiting CI",
+			older:     testBuild("aaa", dev, -time.Hour, BuildAwaitingCI),
+			cancelled: true,
+			state:     BuildCancelled,
+		},
+		{
+			name:      "queued",
+			older:     testBuild("aaa", dev, -time.Hour, BuildQueued),
+			cancelled: true,
+			state:     BuildCancelled,
+		},
+		{
+			name:      "running finishes the started boards",
+			older:     testBuild("aaa", dev, -time.Hour, BuildRunning),
+			cancelled: true,
+			state:     BuildRunning,
+		},
+		{
+			name:      "running stopped immediately",
+			older:     testBuild("aaa", dev, -time.Hour, BuildRunning),
+			immediate: true,
+			cancelled: true,
+			state:     BuildRunning,
+			stopped:   true,
+		},
+		{
+			name:      "building image stopped immediately",
+			older:     testBuild("aaa", dev, -time.Hour, BuildBuildingImage),
+			immediate: true,
+			cancelled: true,
+			state:     BuildBuildingImage,
+			stopped:   true,
+		},
+		{
+			name:  "finished",
+			older: testBuild("aaa", dev, -time.Hour, BuildCompleted),
+			state: BuildCompleted,
+		},
+		{
+			name:  "received later",
+			older: testBuild("aaa", dev, time.Minute, BuildQueued),
+			state: BuildQueued,
+		},
+		{
+			name:  "other branch",
+			older: testBuild("aaa", "tinygo-org/tinygo:release", -time.Hour, BuildQueued),
+			state: BuildQueued,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := supersedeImmediately
+			supersedeImmediately = tt.immediate
+			t.Cleanup(func() { supersedeImmediately = prev })
+
+			newer := testBuild("bbb", dev, 0, BuildAwaitingCI)
+			useBuilds(t, tt.older, newer)
+
+			supersede(newer)
+
+			reason, cancelled := tt.older.cancelled()
+			if cancelled != tt.cancelled {
+				t.Errorf("cancelled = %v, want %v", cancelled, tt.cancelled)
+			}
+			if want := "Superseded by newer commit bbb."; cancelled && reason != want {
+				t.Errorf("reason = %q, want %q", reason, want)
+			}
+			if got := tt.older.getState(); got != tt.state {
+				t.Errorf("state = %s, want %s", got, tt.state)
+			}
+			if stopped := tt.older.ctx.Err() != nil; stopped != tt.stopped {
+				t.Errorf("stopped = %v, want %v", stopped, tt.stopped)
+			}
+			if _, ok := newer.cancelled(); ok {
+				t.Errorf("newer build was cancelled")
+			}
+		})
+	}
+}
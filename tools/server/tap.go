@@ -0,0 +1,106 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcomes of a single test.
+const (
+	outcomePass = "pass"
+	outcomeFail = "fail"
+	outcomeSkip = "skip"
+	outcomeTodo = "todo"
+)
+
+// tapTest is a single test result from the TAP output of the test runner.
+type tapTest struct {
+	Number  int
+	Name    string
+	Outcome string
+
+	// Duration is how long the test took, if the test runner timed it.
+	Duration time.Duration
+
+	// Diagnostics are the lines about the test, which are the comments
+	// and YAML block after it, and any other output from the board
+	// while it ran.
+	Diagnostics []string
+}
+
+var (
+	// a test line, such as "not ok 3 - digitalWrite (GPIO) # SKIP no pin"
+	tapTestLine = regexp.MustCompile(`^(ok|not ok)\s+(\d+)\s*(?:-\s*)?(.*)$`)
+
+	// the time the test runner adds to each test line, such as "# time=12ms"
+	tapTime = regexp.MustCompile(`\s*# time=(\d+)ms$`)
+)
+
+// parseTAP returns the test results in the TAP output from the test runner.
+func parseTAP(out string) []tapTest {
+	var tests []tapTest
+	var pending []string // output before the next test
+	yaml := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		m := tapTestLine.FindStringSubmatch(trimmed)
+		if m == nil {
+			switch {
+			case trimmed == "":
+			case len(tests) > 0 && trimmed == "---":
+				yaml = true
+			case yaml && trimmed == "...":
+				yaml = false
+			case len(tests) > 0 && (yaml || strings.HasPrefix(trimmed, "#")):
+				last := &tests[len(tests)-1]
+				last.Diagnostics = append(last.Diagnostics, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+			case tapHeader(trimmed):
+			default:
+				pending = append(pending, trimmed)
+			}
+			continue
+		}
+		yaml = false
+
+		t := tapTest{Outcome: outcomePass, Diagnostics: pending}
+		pending = nil
+		t.Number, _ = strconv.Atoi(m[2])
+		desc := m[3]
+
+		if tm := tapTime.FindStringSubmatch(desc); tm != nil {
+			ms, _ := strconv.Atoi(tm[1])
+			t.Duration = time.Duration(ms) * time.Millisecond
+			desc = desc[:len(desc)-len(tm[0])]
+		}
+
+		if m[1] == "not ok" {
+			t.Outcome = outcomeFail
+		}
+		name, directive, _ := strings.Cut(desc, "#")
+		directive = strings.TrimSpace(directive)
+		switch upper := strings.ToUpper(directive); {
+		case strings.HasPrefix(upper, "SKIP"):
+			t.Outcome = outcomeSkip
+		case strings.HasPrefix(upper, "TODO"):
+			t.Outcome = outcomeTodo
+		}
+		if directive != "" {
+			t.Diagnostics = append(t.Diagnostics, directive)
+		}
+		t.Name = strings.TrimSpace(name)
+
+		tests = append(tests, t)
+	}
+	return tests
+}
+
+// tapHeader returns true for the lines of TAP output that are not about
+// a single test, such as the version and the plan.
+func tapHeader(line string) bool {
+	return strings.HasPrefix(line, "TAP version") || strings.HasPrefix(line, "1..") ||
+		strings.HasPrefix(line, "===") || strings.HasPrefix(line, "Press 't'")
+}
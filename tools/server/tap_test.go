@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTAP(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []tapTest
+	}{
+		{
+			name: "empty",
+			out:  "",
+			want: nil,
+		},
+		{
+			name: "no tests",
+			out:  "TAP version 13\n1..0\n",
+			want: nil,
+		},
+		{
+			name: "pass and fail",
+			out:  "TAP version 13\nok 1 - digital\nnot ok 2 - i2c\n1..2\n",
+			want: []tapTest{
+				{Number: 1, Name: "digital", Outcome: outcomePass},
+				{Number: 2, Name: "i2c", Outcome: outcomeFail},
+			},
+		},
+		{
+			name: "without dash",
+			out:  "ok 1 digital\nnot ok 2   i2c",
+			want: []tapTest{
+				{Number: 1, Name: "digital", Outcome: outcomePass},
+				{Number: 2, Name: "i2c", Outcome: outcomeFail},
+			},
+		},
+		{
+			name: "directives",
+			out:  "not ok 1 - spi # SKIP no pin\nnot ok 2 - pwm # todo later\nok 3 - adc #skip",
+			want: []tapTest{
+				{Number: 1, Name: "spi", Outcome: outcomeSkip, Diagnostics: []string{"SKIP no pin"}},
+				{Number: 2, Name: "pwm", Outcome: outcomeTodo, Diagnostics: []string{"todo later"}},
+				{Number: 3, Name: "adc", Outcome: outcomeSkip, Diagnostics: []string{"skip"}},
+			},
+		},
+		{
+			name: "time",
+			out:  "ok 1 - digital # time=12ms\nok 2 - uart # SKIP no loopback # time=3ms",
+			want: []tapTest{
+				{Number: 1, Name: "digital", Outcome: outcomePass, Duration: 12 * time.Millisecond},
+				{Number: 2, Name: "uart", Outcome: outcomeSkip, Duration: 3 * time.Millisecond, Diagnostics: []string{"SKIP no loopback"}},
+			},
+		},
+		{
+			name: "diagnostics",
+			out: "TAP version 13\n" +
+				"=== Running tests\n" +
+				"Press 't' to run the tests\n" +
+				"starting i2c\n" +
+				"ok 1 - digital\n" +
+				"not ok 2 - i2c\n" +
+				"  ---\n" +
+				"  message: no ack\n" +
+				"  got: 0\n" +
+				"  ...\n" +
+				"# bus reset\n" +
+				"ok 3 - spi\n",
+			want: []tapTest{
+				{Number: 1, Name: "digital", Outcome: outcomePass, Diagnostics: []string{"starting i2c"}},
+				{Number: 2, Name: "i2c", Outcome: outcomeFail, Diagnostics: []string{"message: no ack", "got: 0", "bus reset"}},
+				{Number: 3, Name: "spi", Outcome: outcomePass},
+			},
+		},
+		{
+			name: "crlf",
+			out:  "TAP version 13\r\nok 1 - digital\r\nnot ok 2 - adc\r\n# read 0\r\n",
+			want: []tapTest{
+				{Number: 1, Name: "digital", Outcome: outcomePass},
+				{Number: 2, Name: "adc", Outcome: outcomeFail, Diagnostics: []string{"read 0"}},
+			},
+		},
+		{
+			name: "output after the last test",
+			out:  "ok 1 - digital\npanic: runtime error\n",
+			want: []tapTest{
+				{Number: 1, Name: "digital", Outcome: outcomePass},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseTAP(tt.out)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTAP() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
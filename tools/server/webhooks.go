@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var errDuplicateDelivery = errors.New("duplicate webhook delivery")
+
+// delivery IDs are GUIDs, so this also keeps them safe to use as file names
+var validDeliveryID = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+// deliveryArchive keeps every webhook delivery from GitHub on disk, one
+// file per delivery, so that duplicates can be ignored and any delivery
+// can be replayed later for debugging.
+type deliveryArchive struct {
+	dir string
+}
+
+// archivedDelivery is a webhook delivery as it is stored on disk.
+type archivedDelivery struct {
+	ID       string          `json:"id"`
+	Event    string          `json:"event"`
+	Received time.Time       `json:"received"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// openDeliveryArchive opens the archive in dir, creating it if needed.
+func openDeliveryArchive(dir string) (*deliveryArchive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &deliveryArchive{dir: dir}, nil
+}
+
+func (a *deliveryArchive) filename(id string) string {
+	return filepath.Join(a.dir, id+".json")
+}
+
+// record adds the delivery to the archive. It returns errDuplicateDelivery
+// if the delivery has already been recorded.
+func (a *deliveryArchive) record(id, event string, payload []byte) error {
+	if !validDeliveryID.MatchString(id) {
+		return errors.New("invalid delivery id " + id)
+	}
+
+	data, err := json.Marshal(archivedDelivery{
+		ID:       id,
+		Event:    event,
+		Received: time.Now(),
+		Payload:  payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	// creating the file fails if it already exists, so that two deliveries
+	// with the same ID at the same time are still only handled once.
+	f, err := os.OpenFile(a.filename(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	switch {
+	case errors.Is(err, os.ErrExist):
+		return errDuplicateDelivery
+	case err != nil:
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+// get returns the delivery with this ID from the archive.
+func (a *deliveryArchive) get(id string) (*archivedDelivery, error) {
+	if !validDeliveryID.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(a.filename(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var d archivedDelivery
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// handleReplay handles an archived delivery again, as if it had just been
+// received from GitHub.
+func handleReplay(w http.ResponseWriter, r *http.Request, buildsCh chan *Build) {
+	if shuttingDown() {
+		http.Error(w, "TinyHCI is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := r.PathValue("delivery")
+	d, err := deliveries.get(id)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("replaying webhook delivery", "delivery", id, "type", d.Event)
+	go handleEvent(d.Event, d.Payload, buildsCh)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireToken only calls the handler if the request has the bearer token.
+func requireToken(token string, handler http.HandlerFunc) http.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
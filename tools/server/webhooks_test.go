@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useDeliveries sets up an empty delivery archive for the test.
+func useDeliveries(t *testing.T) *deliveryArchive {
+	a, err := openDeliveryArchive(t.TempDir() + "/webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := deliveries
+	deliveries = a
+	t.Cleanup(func() { deliveries = prev })
+	return a
+}
+
+func TestRecordDelivery(t *testing.T) {
+	a := useDeliveries(t)
+	const id = "72d3162e-cc78-11e3-81ab-4c9367dc0958"
+	payload := []byte(`{"action":"completed"}`)
+
+	if err := a.record(id, "workflow_run", payload); err != nil {
+		t.Fatalf("record() returned error: %v", err)
+	}
+
+	// GitHub redelivered the same webhook
+	if err := a.record(id, "workflow_run", payload); !errors.Is(err, errDuplicateDelivery) {
+		t.Errorf("record() of a redelivery = %v, want %v", err, errDuplicateDelivery)
+	}
+
+	d, err := a.get(id)
+	if err != nil {
+		t.Fatalf("get() returned error: %v", err)
+	}
+	if d.ID != id || d.Event != "workflow_run" || string(d.Payload) != string(payload) {
+		t.Errorf("get() = %+v, want the recorded delivery", d)
+	}
+
+	if err := a.record("../../etc/passwd", "workflow_run", payload); err == nil {
+		t.Errorf("record() with an invalid id did not return an error")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	a := useDeliveries(t)
+	if err := a.record("1234-abcd", "push", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/replay/{delivery}", requireToken("secret", func(w http.ResponseWriter, r *http.Request) {
+		handleReplay(w, r, make(chan *Build, 1))
+	}))
+
+	tests := []struct {
+		name     string
+		delivery string
+		auth     string
+		status   int
+	}{
+		{name: "no token", delivery: "1234-abcd", status: http.StatusUnauthorized},
+		{name: "wrong token", delivery: "1234-abcd", auth: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "not a bearer token", delivery: "1234-abcd", auth: "secret", status: http.StatusUnauthorized},
+		{name: "replayed", delivery: "1234-abcd", auth: "Bearer secret", status: http.StatusAccepted},
+		{name: "unknown delivery", delivery: "5678-abcd", auth: "Bearer secret", status: http.StatusNotFound},
+		{name: "invalid delivery", delivery: "1234_abcd", auth: "Bearer secret", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/admin/replay/"+tt.delivery, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
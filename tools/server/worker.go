@@ -0,0 +1,219 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// boardJob is a request to flash and test one board for a build.
+type boardJob struct {
+	build *Build
+	done  func()
+}
+
+// worker flashes and tests a single board. Each board has its own
+// worker, so that all of the boards for a build can run at the same time.
+type worker struct {
+	target string
+	jobs   chan *boardJob
+
+	// device is held for as long as the board's device is in use.
+	device sync.Mutex
+
+	statusMu sync.Mutex
+	status   boardStatus
+}
+
+// boardStatus is what a board is doing, and how its last run went.
+type boardStatus struct {
+	SHA   string
+	Phase string
+	Since time.Time
+
+	LastSHA    string
+	LastResult string
+	LastSeen   time.Time
+
+	// Offline is why the board was not found before its last run,
+	// or empty if it was found.
+	Offline string
+
+	// InfraFailures is how many times in a row the board has failed
+	// because of the hardware.
+	InfraFailures int
+
+	// OfflineOnly is set if all of the InfraFailures were because the
+	// board was offline.
+	OfflineOnly bool
+
+	// Quarantined is why the board was taken out of service, or empty
+	// if it is in service.
+	Quarantined      string
+	QuarantinedSince time.Time
+}
+
+var (
+	// key is target
+	workers   = make(map[string]*worker)
+	workersMu sync.Mutex
+)
+
+// startWorker starts the worker for the board with this target,
+// unless it is already running.
+func startWorker(target string) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+
+	if _, ok := workers[target]; ok {
+		return
+	}
+
+	w := &worker{
+		target: target,
+		jobs:   make(chan *boardJob, 16),
+	}
+	workers[target] = w
+
+	go w.run()
+}
+
+// getWorker returns the worker for the board with this target.
+func getWorker(target string) (*worker, bool) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+
+	w, ok := workers[target]
+	return w, ok
+}
+
+// run processes the jobs for this worker's board, one at a time.
+func (w *worker) run() {
+	for job := range w.jobs {
+		// use the board config as it is right now, so that a reload
+		// does not change anything for a run that has already started.
+		board := GetBoard(w.target)
+		if board == nil {
+			job.build.log().Info("board has been removed, so passing", "target", w.target)
+			job.build.passCheckRun(w.target, "Board removed from TinyHCI.")
+			job.done()
+			continue
+		}
+
+		// a build that was cancelled does not start any more boards
+		if reason, ok := job.build.cancelled(); ok {
+			job.build.cancelCheckRun(w.target, reason)
+			job.done()
+			continue
+		}
+
+		if reason, ok := quarantined(w.target); ok && !job.build.options.allBoards {
+			job.build.quarantinedCheckRun(board, reason)
+			job.done()
+			continue
+		}
+
+		// the rest of the runs are left for when the server starts again
+		if shuttingDown() {
+			job.build.requeueCheckRun(w.target, shutdownReason)
+			job.done()
+			continue
+		}
+
+		w.device.Lock()
+		job.build.processBoardRun(board)
+		w.device.Unlock()
+		setPhase(w.target, "", "")
+
+		job.done()
+	}
+}
+
+// runBoards hands off each of the build's check runs to the worker
+// for that board, and waits for all of them to complete.
+func (build *Build) runBoards() {
+	var wg sync.WaitGroup
+	for _, target := range build.targets() {
+		w, ok := getWorker(target)
+		if !ok {
+			build.log().Error("no board found for target", "target", target)
+			build.failCheckRun(target, "Unknown board "+target+" in TinyHCI.")
+			continue
+		}
+
+		wg.Add(1)
+		build.setRunState(target, RunQueued)
+		w.jobs <- &boardJob{build: build, done: wg.Done}
+	}
+
+	wg.Wait()
+}
+
+// setPhase sets what the board is currently doing for the build with this sha.
+// An empty phase means the board is idle.
+func setPhase(target, sha, phase string) {
+	if w, ok := getWorker(target); ok {
+		w.statusMu.Lock()
+		defer w.statusMu.Unlock()
+
+		w.status.SHA = sha
+		w.status.Phase = phase
+		w.status.Since = time.Now()
+	}
+}
+
+// setLastResult records the conclusion of the last run on the board.
+func setLastResult(target, sha, conclusion string) {
+	if w, ok := getWorker(target); ok {
+		w.statusMu.Lock()
+		defer w.statusMu.Unlock()
+
+		w.status.LastSHA = sha
+		w.status.LastResult = conclusion
+	}
+}
+
+// markSeen records that the board was just flashed.
+func markSeen(target string) {
+	if w, ok := getWorker(target); ok {
+		w.statusMu.Lock()
+		defer w.statusMu.Unlock()
+
+		w.status.LastSeen = time.Now()
+	}
+}
+
+// setOffline records whether the board was found before a run, which is
+// offline if err is not nil. An alert is raised when the board goes offline.
+func setOffline(target string, err error) {
+	w, ok := getWorker(target)
+	if !ok {
+		return
+	}
+
+	w.statusMu.Lock()
+	was := w.status.Offline
+	w.status.Offline = ""
+	if err != nil {
+		w.status.Offline = err.Error()
+	}
+	w.statusMu.Unlock()
+
+	switch {
+	case err != nil && was == "":
+		raiseAlert("board_offline", "board "+target+" is offline", "target", target, "err", err)
+	case err == nil && was != "":
+		slog.Info("board is back online", "target", target)
+	}
+}
+
+// getStatus returns the status of the board.
+func getStatus(target string) boardStatus {
+	if w, ok := getWorker(target); ok {
+		w.statusMu.Lock()
+		defer w.statusMu.Unlock()
+
+		return w.status
+	}
+	return boardStatus{}
+}
@@ -83,9 +83,16 @@ func main() {
 	var result strings.Builder
 	timeout := time.After(60 * time.Second)
 
+	// each test line gets how long it took since the previous one
+	last := time.Now()
+
 	for {
 		select {
 		case res := <-ch:
+			if isTestLine(strings.TrimSpace(res)) {
+				res = fmt.Sprintf("%s # time=%dms", strings.TrimRight(res, "\r"), time.Since(last).Milliseconds())
+				last = time.Now()
+			}
 			result.WriteString(res + "\n")
 			lines := strings.Split(res, "\n")
 			for _, line := range lines {
@@ -104,6 +111,8 @@ func main() {
 				goto PARSE
 			}
 		case <-timeout:
+			// print what was received, so that it is clear how far the tests got
+			fmt.Print(result.String())
 			fmt.Println("Timeout waiting for TAP output")
 			os.Exit(1)
 		}
@@ -129,11 +138,16 @@ PARSE:
 	os.Exit(0)
 }
 
+// isTestLine returns true if the line starts with "ok" or "not ok"
+func isTestLine(line string) bool {
+	return strings.HasPrefix(line, "ok") || strings.HasPrefix(line, "not ok")
+}
+
 // countTestLines returns the number of lines starting with "ok" or "not ok"
 func countTestLines(lines []string) int {
 	count := 0
 	for _, line := range lines {
-		if strings.HasPrefix(line, "ok") || strings.HasPrefix(line, "not ok") {
+		if isTestLine(line) {
 			count++
 		}
 	}
@@ -144,7 +158,7 @@ func countTestLines(lines []string) int {
 func extractTestLines(lines []string) []string {
 	var tests []string
 	for _, line := range lines {
-		if strings.HasPrefix(line, "ok") || strings.HasPrefix(line, "not ok") {
+		if isTestLine(line) {
 			tests = append(tests, line)
 		}
 	}